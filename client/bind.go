@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrAlreadyAccepted is returned by BindListener.Accept after the incoming connection has been accepted,
+// as the proxy forwards a single connection for each BIND command
+var ErrAlreadyAccepted = errors.New("socks5: the incoming connection of the bind has already been accepted")
+
+// BindListener is the result of the BIND command. The proxy listens on Addr for a single connection from the peer,
+// which is usually told about Addr over a separate CONNECT connection, as in FTP active mode.
+type BindListener struct {
+	client *Socks5Client
+	addr   net.Addr
+
+	mu       sync.Mutex
+	accepted bool
+}
+
+// Bind sends the BIND command and returns once the proxy has replied with the address it listens on (the first reply).
+// host and port are the address of the peer expected to connect, the proxy may use them to restrict the incoming connection.
+func (client *Socks5Client) Bind(host string, port uint16) (*BindListener, error) {
+	bndAddr, bndPort, err := client.BindRequest(host, port)
+	if err != nil {
+		return nil, err
+	}
+	return &BindListener{client: client, addr: targetAddr(bndAddr, bndPort)}, nil
+}
+
+// Bind connects to the proxy, authenticates and sends the BIND command for the peer at addr (host:port).
+// The deadline and cancellation of ctx apply to the handshake, use the context of BindListener.Accept to limit the wait for the peer.
+func (dialer *Dialer) Bind(ctx context.Context, addr string) (*BindListener, error) {
+	host, port, err := splitHostPort(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "bind", Net: "tcp", Err: err}
+	}
+	conn, err := dialer.dialProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &Socks5Client{state: PendingAuthMethods, tcpConn: conn, options: dialer.Options, ctx: ctx}
+	stop := watchHandshake(ctx, conn)
+	err = client.Connect(dialer.authMethods())
+	var listener *BindListener
+	if err == nil {
+		listener, err = client.Bind(host, port)
+	}
+	if err = stop(err); err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "bind", Net: "tcp", Addr: targetAddr(host, port), Err: err}
+	}
+	return listener, nil
+}
+
+// Addr returns the address on which the proxy listens for the peer (BND.ADDR and BND.PORT of the first reply)
+func (listener *BindListener) Addr() net.Addr {
+	return listener.addr
+}
+
+// Accept waits for the second reply of the proxy, which announces the connection of the peer, and returns the connection.
+// Its RemoteAddr is the address of the peer as reported by the proxy. If ctx is done first, the listener is closed,
+// since the proxy may still send the reply at any time and the connection can't be reused.
+func (listener *BindListener) Accept(ctx context.Context) (net.Conn, error) {
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	if listener.accepted {
+		return nil, ErrAlreadyAccepted
+	}
+	listener.accepted = true
+
+	conn := listener.client.tcpConn
+	stop := watchHandshake(ctx, conn)
+	reply, err := readCommandResponse(conn)
+	if err == nil {
+		err = isCommandSuccessful(reply)
+	}
+	if err = stop(err); err != nil {
+		listener.client.setError(err)
+		conn.Close()
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Addr: listener.addr, Err: err}
+	}
+	return &Conn{Conn: conn, remoteAddr: targetAddr(reply.BND_ADDR.Value, reply.BND_PORT), boundAddr: listener.addr}, nil
+}
+
+// Close abandons the BIND by closing the connection with the proxy. It must not be called once the connection is accepted,
+// the accepted connection has to be closed instead.
+func (listener *BindListener) Close() error {
+	return listener.client.Close()
+}
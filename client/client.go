@@ -8,8 +8,10 @@ import (
 	"net"
 	"socks5_server/messages/requests/available_auth_methods"
 	"socks5_server/messages/requests/command_request"
+	"socks5_server/messages/requests/username_password_auth"
 	"socks5_server/messages/responses/accept_auth_method"
 	"socks5_server/messages/responses/command_response"
+	"socks5_server/messages/responses/username_password_status"
 	"socks5_server/messages/shared"
 )
 
@@ -31,6 +33,20 @@ type Socks5Client struct {
 	state   ConnectionState
 	tcpConn net.Conn
 	err     error
+	options ClientOptions
+	ctx     context.Context
+}
+
+// ClientOptions holds the optional configuration of the client. The zero value is valid and means no credentials are available.
+type ClientOptions struct {
+	// Username and Password are used when the server selects the Username/Password method (RFC1929)
+	Username string
+	Password string
+	// ResolveLocally makes the client resolve hostnames itself and send the IP to the server (socks5:// semantics).
+	// By default hostnames are send as ATYP_FQDN and resolved by the server (socks5h:// semantics), which allows reaching names only resolvable on the proxy side.
+	ResolveLocally bool
+	// Resolver is used when ResolveLocally is set. Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
 }
 
 func (client *Socks5Client) State() ConnectionState {
@@ -52,25 +68,33 @@ func (client *Socks5Client) setError(err error) {
 }
 
 // NewSocks5Client Creates new client bound to context and connect to given proxy server. The connection is not start with the creation!
+// The client is closed when ctx is done, see Dialer for connections which outlive the context used to establish them.
 func NewSocks5Client(ctx context.Context, servAddr string) (*Socks5Client, error) {
-	conn, err := openTcpConnection(servAddr)
+	return NewSocks5ClientWithOptions(ctx, servAddr, ClientOptions{})
+}
+
+// NewSocks5ClientWithOptions is like NewSocks5Client, but allows configuring the client, i.e. providing credentials
+func NewSocks5ClientWithOptions(ctx context.Context, servAddr string, options ClientOptions) (*Socks5Client, error) {
+	conn, err := openTcpConnection(ctx, servAddr)
 	if err != nil {
 		return nil, err
 	}
 	client := &Socks5Client{}
 	client.state = PendingAuthMethods
 	client.tcpConn = conn
+	client.options = options
+	client.ctx = ctx
 	go func() {
 		select {
 		case <-ctx.Done():
-			client.setState(Closed)
-			_ = client.tcpConn.Close()
+			_ = client.Close()
 		}
 	}()
 	return client, nil
 }
 
 // Connect Start initial connection ot the proxy, by sending the authentication methods supported by the client. After this method is called the handleAuth method (which expects the response with the chose auth method) is called synchronously.
+// If the server selects Username/Password, the credentials from ClientOptions are send and AuthenticationFailedError is returned when the server rejects them.
 func (client *Socks5Client) Connect(authMethods []uint16) error {
 	aam := available_auth_methods.AvailableAuthMethods{}
 
@@ -85,7 +109,11 @@ func (client *Socks5Client) Connect(authMethods []uint16) error {
 		return err
 	}
 	client.setState(ExpectingAcceptedAuthMethod)
-	return client.handleAuth()
+	if err := client.handleAuth(); err != nil {
+		client.setError(err)
+		return err
+	}
+	return nil
 }
 
 // ConnectRequest Send a Connect command request to the proxy server
@@ -94,7 +122,7 @@ func (client *Socks5Client) ConnectRequest(addr string, port uint16) (string, ui
 		return "", 0, errors.New("client is not authenticated")
 	}
 
-	err := client.constructAndSendCommand(command_request.CONNECT, addr, shared.ATYP_IPV4, port)
+	err := client.constructAndSendCommand(command_request.CONNECT, addr, port)
 	if err != nil {
 		client.setError(err)
 		return "", 0, err
@@ -113,7 +141,7 @@ func (client *Socks5Client) BindRequest(addr string, port uint16) (string, uint1
 		return "", 0, errors.New("client is not authenticated")
 	}
 
-	err := client.constructAndSendCommand(command_request.BIND, addr, shared.ATYP_IPV4, port)
+	err := client.constructAndSendCommand(command_request.BIND, addr, port)
 	if err != nil {
 		client.setError(err)
 		return "", 0, err
@@ -135,7 +163,7 @@ func (client *Socks5Client) UDPAssociateRequest(addr string, port uint16) (strin
 		return "", 0, errors.New("client is not authenticated")
 	}
 
-	err := client.constructAndSendCommand(command_request.UDP_ASSOCIATE, addr, shared.ATYP_IPV4, port)
+	err := client.constructAndSendCommand(command_request.UDP_ASSOCIATE, addr, port)
 	if err != nil {
 		client.setError(err)
 		return "", 0, err
@@ -150,7 +178,10 @@ func (client *Socks5Client) UDPAssociateRequest(addr string, port uint16) (strin
 	return addrProxy, portProxy, nil
 }
 func (client *Socks5Client) Close() error {
-	client.setState(Closed)
+	// Errored is final, the client is closed but the state keeps the reason for it
+	if client.state != Errored {
+		client.setState(Closed)
+	}
 	return client.tcpConn.Close()
 }
 
@@ -163,8 +194,12 @@ func (client *Socks5Client) GetReaderWriter() (io.ReadWriter, error) {
 }
 
 // Private
-func (client *Socks5Client) constructAndSendCommand(cmdType uint16, addr string, addrType uint16, port uint16) error {
-	req, err := constructCommand(cmdType, addr, addrType, port)
+func (client *Socks5Client) constructAndSendCommand(cmdType uint16, addr string, port uint16) error {
+	addr, err := client.resolveIfRequired(client.ctx, addr)
+	if err != nil {
+		return err
+	}
+	req, err := constructCommand(cmdType, addr, port)
 	if err != nil {
 		return err
 	}
@@ -177,6 +212,29 @@ func (client *Socks5Client) constructAndSendCommand(cmdType uint16, addr string,
 	client.setState(CommandRequested)
 	return nil
 }
+
+// Resolves hostnames to IP when ResolveLocally is set, IP literals are returned as they are.
+// The first address is used, preferring IPv4 as it is the most widely supported by servers.
+func (client *Socks5Client) resolveIfRequired(ctx context.Context, addr string) (string, error) {
+	if !client.options.ResolveLocally || net.ParseIP(addr) != nil {
+		return addr, nil
+	}
+	resolver := client.options.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIP(ctx, "ip", addr)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.String(), nil
+		}
+	}
+	return ips[0].String(), nil
+}
+
 func (client *Socks5Client) handleAuth() error {
 	if client.state != ExpectingAcceptedAuthMethod {
 		return errors.New("client is not expecting accepted auth clients")
@@ -190,17 +248,56 @@ func (client *Socks5Client) handleAuth() error {
 	if err := acceptedMethod.Deserialize(buf); err != nil {
 		return err
 	}
-	if acceptedMethod.Method() != shared.NoAuthRequired {
+	switch acceptedMethod.Method() {
+	case shared.NoAuthRequired:
+	case shared.UsernameAndPassword:
 		client.setState(PendingAuthentication)
+		if err := client.handleUsernamePasswordAuth(); err != nil {
+			return err
+		}
+	default:
+		return NoAcceptableAuthMethodError{Method: acceptedMethod.Method()}
 	}
 	client.setState(Authenticated)
 	return nil
 }
+
+// Executes the Username/Password sub-negotiation as defined in RFC1929
+func (client *Socks5Client) handleUsernamePasswordAuth() error {
+	if client.options.Username == "" {
+		return NoAcceptableAuthMethodError{Method: shared.UsernameAndPassword}
+	}
+	credentials := username_password_auth.UsernamePasswordAuth{}
+	if err := credentials.SetUsername(client.options.Username); err != nil {
+		return err
+	}
+	if err := credentials.SetPassword(client.options.Password); err != nil {
+		return err
+	}
+	_, err := client.tcpConn.Write(credentials.ToBytes())
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64)
+	n, err := client.tcpConn.Read(buf)
+	if err != nil {
+		return err
+	}
+	status := username_password_status.UsernamePasswordStatus{}
+	if err := status.Deserialize(buf[:n]); err != nil {
+		return err
+	}
+	if !status.IsSuccessful() {
+		return AuthenticationFailedError{Username: client.options.Username, Status: status.Status}
+	}
+	return nil
+}
 func (client *Socks5Client) handleCommandResponse() (string, uint16, error) {
 	if client.State() != CommandRequested {
 		return "", 0, errors.New("client has not requested command")
 	}
-	commandResponse, err := waitForServerCommandResponse(client.tcpConn)
+	commandResponse, err := readCommandResponse(client.tcpConn)
 	if err != nil {
 		return "", 0, err
 	}
@@ -216,42 +313,56 @@ func (client *Socks5Client) handleCommandResponse() (string, uint16, error) {
 
 func isCommandSuccessful(cmd *command_response.CommandResponse) error {
 	if cmd.Status != command_response.Success {
-		errMsg := fmt.Sprintf("server didn't respond with success, responed with %v", cmd.Status)
-		return errors.New(errMsg)
+		return CommandFailedError{Status: cmd.Status}
 	}
 	return nil
 }
 
-func waitForServerCommandResponse(client net.Conn) (*command_response.CommandResponse, error) {
-	buf := make([]byte, 64)
-	_, err := client.Read(buf)
-	if err != nil {
+// Reads exactly one reply from conn, as the data following it must be left to the caller, i.e. the data send by the peer of BIND
+func readCommandResponse(conn io.Reader) (*command_response.CommandResponse, error) {
+	// VER, REP, RSV and ATYP, followed by the address and the port
+	buf := make([]byte, 4, 4+1+255+2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	var addrLen int
+	switch atyp := uint16(buf[3]); atyp {
+	case shared.ATYP_IPV4:
+		addrLen = net.IPv4len
+	case shared.ATYP_IPV6:
+		addrLen = net.IPv6len
+	case shared.ATYP_FQDN:
+		buf = buf[:5]
+		if _, err := io.ReadFull(conn, buf[4:]); err != nil {
+			return nil, err
+		}
+		addrLen = int(buf[4])
+	default:
+		return nil, &command_response.InvalidAtypError{Atyp: atyp}
+	}
+	start := len(buf)
+	buf = buf[:start+addrLen+2]
+	if _, err := io.ReadFull(conn, buf[start:]); err != nil {
 		return nil, err
 	}
 
 	commandResponse := command_response.CommandResponse{}
-	err = commandResponse.Deserialize(buf)
-	if err != nil {
+	if err := commandResponse.Deserialize(buf); err != nil {
 		return nil, err
 	}
 	return &commandResponse, nil
 }
-func openTcpConnection(servAddr string) (*net.TCPConn, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", servAddr)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
-	if err != nil {
-		return nil, err
-	}
-	return conn, nil
+
+func openTcpConnection(ctx context.Context, servAddr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", servAddr)
 }
 
-func constructCommand(cmdType uint16, addr string, addrType uint16, port uint16) ([]byte, error) {
+// The address type is chosen from addr, see shared.NewDstAddr
+func constructCommand(cmdType uint16, addr string, port uint16) ([]byte, error) {
 	commandRequest := command_request.CommandRequest{}
 	commandRequest.CMD = cmdType
-	commandRequest.DST_ADDR = shared.DstAddr{Value: addr, Type: addrType}
+	commandRequest.DST_ADDR = shared.NewDstAddr(addr)
 	commandRequest.DST_PORT = port
 	return commandRequest.ToBytes()
 }
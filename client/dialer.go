@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"socks5_server/messages/shared"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dialer connects to targets through a socks5 proxy with the CONNECT command. Its DialContext can be used as
+// http.Transport.DialContext and it implements proxy.Dialer and proxy.ContextDialer of golang.org/x/net/proxy.
+// The zero value is not valid, ProxyAddress must be set.
+type Dialer struct {
+	// ProxyAddress is the host:port of the socks5 server
+	ProxyAddress string
+	// Options holds the credentials and the resolution mode, see ClientOptions
+	Options ClientOptions
+	// AuthMethods are offered to the server. Defaults to No Auth, and Username/Password when Options.Username is set.
+	AuthMethods []uint16
+	// Forward opens the connection to the proxy, i.e. to chain proxies. Defaults to net.Dialer.
+	Forward proxy.Dialer
+}
+
+// NewDialer creates a Dialer for the proxy at proxyAddress
+func NewDialer(proxyAddress string, options ClientOptions) *Dialer {
+	return &Dialer{ProxyAddress: proxyAddress, Options: options}
+}
+
+// Dial is like DialContext with context.Background()
+func (dialer *Dialer) Dial(network string, addr string) (net.Conn, error) {
+	return dialer.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the proxy, authenticates and requests a connection to addr. Only the tcp networks are supported.
+// The deadline and cancellation of ctx apply to every step of the handshake, once the connection is returned ctx has no effect on it.
+func (dialer *Dialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	host, port, err := splitHostPort(ctx, network, addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	conn, err := dialer.dialProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &Socks5Client{state: PendingAuthMethods, tcpConn: conn, options: dialer.Options, ctx: ctx}
+	stop := watchHandshake(ctx, conn)
+	bndAddr, bndPort, err := client.connectAndRequest(dialer.authMethods(), host, port)
+	if err = stop(err); err != nil {
+		conn.Close()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: targetAddr(host, port), Err: err}
+	}
+	return &Conn{Conn: conn, remoteAddr: targetAddr(host, port), boundAddr: targetAddr(bndAddr, bndPort)}, nil
+}
+
+// Connects to the proxy with Forward, using its DialContext when it implements proxy.ContextDialer
+func (dialer *Dialer) dialProxy(ctx context.Context) (net.Conn, error) {
+	switch forward := dialer.Forward.(type) {
+	case nil:
+		return openTcpConnection(ctx, dialer.ProxyAddress)
+	case proxy.ContextDialer:
+		return forward.DialContext(ctx, "tcp", dialer.ProxyAddress)
+	default:
+		return forward.Dial("tcp", dialer.ProxyAddress)
+	}
+}
+
+func (dialer *Dialer) authMethods() []uint16 {
+	if len(dialer.AuthMethods) != 0 {
+		return dialer.AuthMethods
+	}
+	if dialer.Options.Username != "" {
+		return []uint16{shared.NoAuthRequired, shared.UsernameAndPassword}
+	}
+	return []uint16{shared.NoAuthRequired}
+}
+
+// Authenticates and sends the CONNECT command, returning the BND.ADDR and BND.PORT of the reply
+func (client *Socks5Client) connectAndRequest(authMethods []uint16, host string, port uint16) (string, uint16, error) {
+	if err := client.Connect(authMethods); err != nil {
+		return "", 0, err
+	}
+	return client.ConnectRequest(host, port)
+}
+
+// Conn is a connection to a target through the proxy, as returned by Dialer
+type Conn struct {
+	net.Conn
+	remoteAddr net.Addr
+	boundAddr  net.Addr
+}
+
+// RemoteAddr returns the address of the target, not the one of the proxy
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// BoundAddr returns the address used by the proxy for the connection to the target (BND.ADDR and BND.PORT)
+func (conn *Conn) BoundAddr() net.Addr {
+	return conn.boundAddr
+}
+
+// CloseWrite shuts down the writing side of the connection with the proxy, so the target sees EOF while the replies can still be read
+func (conn *Conn) CloseWrite() error {
+	if closer, ok := conn.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return conn.Conn.Close()
+}
+
+// Addr is the address of a target reached through the proxy, when it is a hostname resolved by the proxy
+type Addr struct {
+	Net  string
+	Host string
+	Port uint16
+}
+
+func (addr Addr) Network() string {
+	return addr.Net
+}
+
+func (addr Addr) String() string {
+	return net.JoinHostPort(addr.Host, strconv.Itoa(int(addr.Port)))
+}
+
+// Returns *net.TCPAddr for IP literals and Addr for hostnames
+func targetAddr(host string, port uint16) net.Addr {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	return Addr{Net: "tcp", Host: host, Port: port}
+}
+
+// Splits host:port, the port can also be a service name, i.e. "http"
+func splitHostPort(ctx context.Context, network string, addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, portStr)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, uint16(port), nil
+}
+
+// Applies the deadline and the cancellation of ctx to the handshake on conn. The returned function must be called with the
+// result of the handshake, it clears the deadline and returns ctx.Err() if the context interrupted the handshake.
+func watchHandshake(ctx context.Context, conn net.Conn) func(err error) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblocks the pending read or write
+			_ = conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func(err error) error {
+		close(done)
+		if <-interrupted {
+			return ctx.Err()
+		}
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the deadline of conn may expire slightly before the one of ctx is reported
+		if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+			return context.DeadlineExceeded
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return err
+	}
+}
+
+var (
+	_ proxy.Dialer        = (*Dialer)(nil)
+	_ proxy.ContextDialer = (*Dialer)(nil)
+)
@@ -0,0 +1,33 @@
+package client
+
+import "fmt"
+
+// AuthenticationFailedError is returned when the server rejects the credentials during the Username/Password sub-negotiation
+type AuthenticationFailedError struct {
+	Username string
+	Status   uint16
+}
+
+func (e AuthenticationFailedError) Error() string {
+	return fmt.Sprintf("server rejected credentials for user %q with status %d", e.Username, e.Status)
+}
+
+// NoAcceptableAuthMethodError is returned when the server doesn't support any of the auth methods offered by the client,
+// or when it selects a method for which the client has no configuration.
+type NoAcceptableAuthMethodError struct {
+	Method uint16
+}
+
+func (e NoAcceptableAuthMethodError) Error() string {
+	return fmt.Sprintf("no acceptable auth method, server selected %d", e.Method)
+}
+
+// CommandFailedError is returned when the server replies to a command with status other than Success.
+// The status is one of the reply codes defined in command_response, i.e. command_response.ConnectionRefused
+type CommandFailedError struct {
+	Status uint16
+}
+
+func (e CommandFailedError) Error() string {
+	return fmt.Sprintf("server didn't respond with success, responed with %v", e.Status)
+}
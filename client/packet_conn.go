@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Maximum size of a datagram received from the relay, header included
+const maxDatagramSize = 65535
+
+// PacketConn sends and receives datagrams through the UDP relay of the proxy, as returned by UDPAssociate.
+// The association lasts as long as the TCP connection with the proxy, which is kept open until Close.
+// When the proxy closes the TCP connection, the association is over and the pending reads fail.
+type PacketConn struct {
+	client *Socks5Client
+	relay  *net.UDPConn
+
+	readMu  sync.Mutex // guards readBuf
+	readBuf []byte
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// UDPAssociate requests a UDP association and returns the connection to the relay. The client must be authenticated.
+// The client address is send as all zeros, since the client doesn't know the address the proxy will see (RFC1928 section 7).
+func (client *Socks5Client) UDPAssociate() (*PacketConn, error) {
+	relayHost, relayPort, err := client.UDPAssociateRequest("0.0.0.0", 0)
+	if err != nil {
+		return nil, err
+	}
+	// servers listening on all interfaces report the unspecified address, the relay is then reachable on the proxy's address
+	relayIp := net.ParseIP(relayHost)
+	if relayIp == nil || relayIp.IsUnspecified() {
+		proxyHost, _, err := net.SplitHostPort(client.tcpConn.RemoteAddr().String())
+		if err != nil {
+			client.setError(err)
+			return nil, err
+		}
+		relayIp = net.ParseIP(proxyHost)
+	}
+	relay, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: relayIp, Port: int(relayPort)})
+	if err != nil {
+		client.setError(err)
+		return nil, err
+	}
+	conn := &PacketConn{client: client, relay: relay, readBuf: make([]byte, maxDatagramSize)}
+	go conn.watchControlConnection()
+	return conn, nil
+}
+
+// ListenPacket connects to the proxy, authenticates and requests a UDP association.
+// The deadline and cancellation of ctx apply to the handshake, once the connection is returned ctx has no effect on it.
+func (dialer *Dialer) ListenPacket(ctx context.Context) (*PacketConn, error) {
+	conn, err := dialer.dialProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &Socks5Client{state: PendingAuthMethods, tcpConn: conn, options: dialer.Options, ctx: ctx}
+	stop := watchHandshake(ctx, conn)
+	err = client.Connect(dialer.authMethods())
+	var packetConn *PacketConn
+	if err == nil {
+		packetConn, err = client.UDPAssociate()
+	}
+	if err = stop(err); err != nil {
+		if packetConn != nil {
+			packetConn.Close()
+		}
+		conn.Close()
+		return nil, &net.OpError{Op: "listen", Net: "udp", Err: err}
+	}
+	return packetConn, nil
+}
+
+// ReadFrom reads the payload of the next datagram from the relay into p and returns the address of the remote which send it.
+// The address is *net.UDPAddr, or Addr when the proxy reports a hostname. Fragmented datagrams are dropped, as the client doesn't reassemble them.
+func (conn *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+	for {
+		n, err := conn.relay.Read(conn.readBuf)
+		if err != nil {
+			return 0, nil, err
+		}
+		dgram := udp.UDPDatagram{}
+		if err := dgram.Deserialize(conn.readBuf[:n]); err != nil || dgram.Frag != 0 {
+			continue
+		}
+		return copy(p, dgram.DATA), remoteAddr(dgram.DST_ADDR.Value, dgram.DST_PORT), nil
+	}
+}
+
+// WriteTo encapsulates p and sends it to addr through the relay. Hostnames are resolved by the proxy, unless ResolveLocally is set.
+func (conn *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	host, port, err := splitAddr(addr)
+	if err != nil {
+		return 0, err
+	}
+	host, err = conn.client.resolveIfRequired(context.Background(), host)
+	if err != nil {
+		return 0, err
+	}
+	dgram := udp.UDPDatagram{DST_ADDR: shared.NewDstAddr(host), DST_PORT: port, DATA: p}
+	data, err := dgram.ToBytes()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := conn.relay.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the association by closing both the connection to the relay and the TCP connection with the proxy
+func (conn *PacketConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.closeErr = errors.Join(conn.relay.Close(), conn.client.Close())
+	})
+	return conn.closeErr
+}
+
+// LocalAddr returns the local address of the connection to the relay
+func (conn *PacketConn) LocalAddr() net.Addr {
+	return conn.relay.LocalAddr()
+}
+
+// RelayAddr returns the address of the relay of the proxy
+func (conn *PacketConn) RelayAddr() net.Addr {
+	return conn.relay.RemoteAddr()
+}
+
+func (conn *PacketConn) SetDeadline(t time.Time) error {
+	return conn.relay.SetDeadline(t)
+}
+
+func (conn *PacketConn) SetReadDeadline(t time.Time) error {
+	return conn.relay.SetReadDeadline(t)
+}
+
+func (conn *PacketConn) SetWriteDeadline(t time.Time) error {
+	return conn.relay.SetWriteDeadline(t)
+}
+
+// The proxy doesn't send anything over the TCP connection during the association, so a completed read means it was closed
+func (conn *PacketConn) watchControlConnection() {
+	_, _ = io.Copy(io.Discard, conn.client.tcpConn)
+	conn.Close()
+}
+
+// Returns *net.UDPAddr for IP literals and Addr for hostnames
+func remoteAddr(host string, port uint16) net.Addr {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	return Addr{Net: "udp", Host: host, Port: port}
+}
+
+// Returns the host and port of the address, which is either one of the address types of the net package or Addr
+func splitAddr(addr net.Addr) (string, uint16, error) {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP.String(), uint16(addr.Port), nil
+	case Addr:
+		return addr.Host, addr.Port, nil
+	}
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, uint16(port), nil
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
@@ -2,11 +2,17 @@ package sockstests
 
 import (
 	"fmt"
+	"io"
 	"net"
 )
 
 func TcpEchoServer() (string, uint16) {
-	srv, err := net.Listen("tcp4", "127.0.0.1:0")
+	return TcpEchoServerOn("127.0.0.1:0")
+}
+
+// TcpEchoServerOn is like TcpEchoServer, but listens on the given address, i.e. "[::1]:0" for IPv6
+func TcpEchoServerOn(address string) (string, uint16) {
+	srv, err := net.Listen("tcp", address)
 	if err != nil {
 		panic(err)
 	}
@@ -29,12 +35,40 @@ func TcpEchoServer() (string, uint16) {
 	return addr, uint16(port)
 }
 
+// TcpSinkServer accepts a single client and discards everything it sends, without ever closing the connection by itself
+func TcpSinkServer() (string, uint16) {
+	srv, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		client, err := srv.Accept()
+		if err != nil {
+			panic(err)
+		}
+		_, _ = io.Copy(io.Discard, client)
+		client.Close()
+	}()
+	addr := srv.Addr().(*net.TCPAddr).IP.String()
+	port := srv.Addr().(*net.TCPAddr).Port
+	return addr, uint16(port)
+}
+
 func UdpEchoServer() (string, uint16) {
-	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:9999")
+	return UdpEchoServerOn("127.0.0.1:9999")
+}
+
+// UdpEchoServerOn is like UdpEchoServer, but listens on the given address, i.e. "[::1]:0" for IPv6
+func UdpEchoServerOn(address string) (string, uint16) {
+	addr, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
 		panic(err)
 	}
 	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		panic(err)
+	}
 
 	go func() {
 		for {
@@ -48,8 +82,8 @@ func UdpEchoServer() (string, uint16) {
 			conn.WriteToUDP(buf[0:n], addr)
 		}
 	}()
-	srvAddr := addr.IP.String()
-	srvPort := addr.Port
+	srvAddr := conn.LocalAddr().(*net.UDPAddr).IP.String()
+	srvPort := conn.LocalAddr().(*net.UDPAddr).Port
 	return srvAddr, uint16(srvPort)
 }
 
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"socks5_server/client"
+	"strconv"
+)
+
+func runBind(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("bind", flag.ContinueOnError)
+	opts := commonFlags(flags)
+	host, port, err := parseTarget(flags, args)
+	if err != nil {
+		return err
+	}
+
+	dialer := client.NewDialer(opts.proxy, opts.clientOptions())
+	listener, err := dialer.Bind(ctx, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "bound", listener.Addr())
+
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	fmt.Fprintln(os.Stderr, "accepted", conn.RemoteAddr())
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	return pipe(conn)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"socks5_server/client"
+	"strconv"
+)
+
+func runConnect(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("connect", flag.ContinueOnError)
+	opts := commonFlags(flags)
+	host, port, err := parseTarget(flags, args)
+	if err != nil {
+		return err
+	}
+
+	dialer := client.NewDialer(opts.proxy, opts.clientOptions())
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// the dialer's context only covers the handshake, the tunnel is closed on SIGINT/SIGTERM here
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	return pipe(conn)
+}
+
+// Copies stdin to rw and rw to stdout. Once stdin is exhausted, the write side of rw is closed (if supported),
+// so the remote side sees EOF. Returns when rw has no more data.
+func pipe(rw io.ReadWriter) error {
+	go func() {
+		_, _ = io.Copy(rw, os.Stdin)
+		if closer, ok := rw.(interface{ CloseWrite() error }); ok {
+			_ = closer.CloseWrite()
+		}
+	}()
+	_, err := io.Copy(os.Stdout, rw)
+	return err
+}
@@ -0,0 +1,90 @@
+// Command socks5c exercises a socks5 server with the three commands defined in RFC1928:
+//
+//	socks5c connect [flags] host port   pipes stdin/stdout through a CONNECT tunnel, like netcat
+//	socks5c bind [flags] host port      prints the address bound by the proxy and pipes the incoming connection to stdin/stdout
+//	socks5c udp [flags] host port       sends every line from stdin as a datagram through UDP ASSOCIATE and prints the replies
+//
+// It can be used for interop checks against any socks5 server.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"socks5_server/client"
+	"strconv"
+	"syscall"
+)
+
+const usage = `usage: socks5c <connect|bind|udp> [flags] host port
+
+Run "socks5c <command> -h" for the flags of each command.
+`
+
+// options shared by all commands
+type options struct {
+	proxy          string
+	username       string
+	password       string
+	resolveLocally bool
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "socks5c:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return errors.New("missing command")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "connect":
+		return runConnect(ctx, args[1:])
+	case "bind":
+		return runBind(ctx, args[1:])
+	case "udp":
+		return runUdp(ctx, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// Registers the flags shared by all commands
+func commonFlags(flags *flag.FlagSet) *options {
+	opts := &options{}
+	flags.StringVar(&opts.proxy, "proxy", "127.0.0.1:1080", "address of the socks5 server")
+	flags.StringVar(&opts.username, "user", "", "username for the Username/Password auth method")
+	flags.StringVar(&opts.password, "pass", "", "password for the Username/Password auth method")
+	flags.BoolVar(&opts.resolveLocally, "resolve-locally", false, "resolve hostnames locally instead of on the proxy (socks5 instead of socks5h)")
+	return opts
+}
+
+func (opts *options) clientOptions() client.ClientOptions {
+	return client.ClientOptions{Username: opts.username, Password: opts.password, ResolveLocally: opts.resolveLocally}
+}
+
+// Parses the flags and the host port positional arguments
+func parseTarget(flags *flag.FlagSet, args []string) (string, uint16, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", 0, err
+	}
+	if flags.NArg() != 2 {
+		return "", 0, errors.New("expected host and port")
+	}
+	port, err := strconv.ParseUint(flags.Arg(1), 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", flags.Arg(1))
+	}
+	return flags.Arg(0), uint16(port), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"socks5_server/client"
+	"time"
+)
+
+func runUdp(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("udp", flag.ContinueOnError)
+	opts := commonFlags(flags)
+	linger := flags.Duration("linger", time.Second, "time to wait for replies after stdin is exhausted")
+	host, port, err := parseTarget(flags, args)
+	if err != nil {
+		return err
+	}
+
+	dialer := client.NewDialer(opts.proxy, opts.clientOptions())
+	conn, err := dialer.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	target := client.Addr{Net: "udp", Host: host, Port: port}
+	go printReplies(conn)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if _, err := conn.WriteTo(scanner.Bytes(), target); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(*linger):
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// Prints every datagram received from the relay together with the address of the remote which send it
+func printReplies(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		fmt.Printf("%s: %s\n", from, buf[:n])
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"socks5_server/messages/requests/command_request"
+	"socks5_server/server"
+	"socks5_server/server/dialers"
+	"socks5_server/server/proxies"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Names of the auth methods, commands and UDP fragment policies, as used in the config file and the flags
+const (
+	authMethodNone             = "none"
+	authMethodUsernamePassword = "username_password"
+
+	commandConnect      = "connect"
+	commandBind         = "bind"
+	commandUdpAssociate = "udp_associate"
+
+	udpFragmentsDrop       = "drop"
+	udpFragmentsReassemble = "reassemble"
+)
+
+// config is the representation of the config file, which is YAML or JSON depending on its extension. Every field can be overridden by the corresponding flag.
+type config struct {
+	Listen          string              `json:"listen" yaml:"listen"`
+	AuthMethods     []string            `json:"auth_methods" yaml:"auth_methods"`
+	CredentialsFile string              `json:"credentials_file" yaml:"credentials_file"`
+	DialTimeout     duration            `json:"dial_timeout" yaml:"dial_timeout"`
+	KeepAlive       duration            `json:"keep_alive" yaml:"keep_alive"`
+	SourceAddress   string              `json:"source_address" yaml:"source_address"`
+	BindIP          string              `json:"bind_ip" yaml:"bind_ip"`
+	BindPorts       string              `json:"bind_ports" yaml:"bind_ports"`
+	BindExternalIP  string              `json:"bind_external_ip" yaml:"bind_external_ip"`
+	BindTimeout     duration            `json:"bind_timeout" yaml:"bind_timeout"`
+	UDPFragments    string              `json:"udp_fragments" yaml:"udp_fragments"`
+	IdleTimeouts    map[string]duration `json:"idle_timeouts" yaml:"idle_timeouts"`
+	ShutdownTimeout duration            `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	AllowedCommands []string            `json:"allowed_commands" yaml:"allowed_commands"`
+	MaxConnections  int                 `json:"max_connections" yaml:"max_connections"`
+	LogLevel        string              `json:"log_level" yaml:"log_level"`
+}
+
+// duration allows durations in the config file to be written as strings, i.e. "5s"
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+func (d *duration) UnmarshalYAML(node *yaml.Node) error {
+	var value string
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+	return d.parse(value)
+}
+
+func (d *duration) parse(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func defaultConfig() config {
+	return config{
+		Listen:          "127.0.0.1:1080",
+		AuthMethods:     []string{authMethodNone},
+		DialTimeout:     duration{server.DefaultDialTimeout},
+		BindTimeout:     duration{server.DefaultBindAcceptTimeout},
+		UDPFragments:    udpFragmentsDrop,
+		ShutdownTimeout: duration{30 * time.Second},
+		AllowedCommands: []string{commandConnect, commandBind, commandUdpAssociate},
+		LogLevel:        "info",
+	}
+}
+
+// Reads the config file on top of the defaults. Fields missing from the file keep their default value.
+// Files with the .yaml or .yml extension are parsed as YAML, any other as JSON.
+func loadConfigFile(path string, cfg *config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(file)
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+	default:
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Converts the config to server.Config, loading the credentials if the username_password method is enabled
+func (cfg *config) serverConfig(logger *slog.Logger) (server.Config, error) {
+	var err error
+	srvConfig := server.Config{
+		DialTimeout:       cfg.DialTimeout.Duration,
+		BindAcceptTimeout: cfg.BindTimeout.Duration,
+		MaxConnections:    cfg.MaxConnections,
+		Logger:            logger,
+	}
+
+	dialer := dialers.NetDialer{Timeout: cfg.DialTimeout.Duration, KeepAlive: cfg.KeepAlive.Duration}
+	if cfg.SourceAddress != "" {
+		if dialer.LocalIP = net.ParseIP(cfg.SourceAddress); dialer.LocalIP == nil {
+			return server.Config{}, fmt.Errorf("invalid source address %q", cfg.SourceAddress)
+		}
+	}
+	srvConfig.Dialer = dialer
+
+	if srvConfig.BindIP, err = parseOptionalIP("bind IP", cfg.BindIP); err != nil {
+		return server.Config{}, err
+	}
+	if srvConfig.BindExternalIP, err = parseOptionalIP("bind external IP", cfg.BindExternalIP); err != nil {
+		return server.Config{}, err
+	}
+	if srvConfig.BindPorts, err = parsePortRange(cfg.BindPorts); err != nil {
+		return server.Config{}, err
+	}
+
+	switch cfg.UDPFragments {
+	case udpFragmentsDrop:
+		srvConfig.UDPFragments = proxies.FragmentsDrop
+	case udpFragmentsReassemble:
+		srvConfig.UDPFragments = proxies.FragmentsReassemble
+	default:
+		return server.Config{}, fmt.Errorf("unknown udp fragments policy %q", cfg.UDPFragments)
+	}
+
+	for _, method := range cfg.AuthMethods {
+		switch method {
+		case authMethodNone:
+			srvConfig.Authenticators = append(srvConfig.Authenticators, server.NoAuthAuthenticator{})
+		case authMethodUsernamePassword:
+			if cfg.CredentialsFile == "" {
+				return server.Config{}, errors.New("the username_password auth method requires a credentials file")
+			}
+			credentials, err := server.LoadCredentialsFile(cfg.CredentialsFile)
+			if err != nil {
+				return server.Config{}, err
+			}
+			srvConfig.Authenticators = append(srvConfig.Authenticators, &server.UsernamePasswordAuthenticator{Credentials: credentials})
+		default:
+			return server.Config{}, fmt.Errorf("unknown auth method %q", method)
+		}
+	}
+	if len(srvConfig.Authenticators) == 0 {
+		return server.Config{}, errors.New("at least one auth method must be enabled")
+	}
+
+	srvConfig.AllowedCommands = []uint16{}
+	for _, command := range cfg.AllowedCommands {
+		code, err := commandCode(command)
+		if err != nil {
+			return server.Config{}, err
+		}
+		srvConfig.AllowedCommands = append(srvConfig.AllowedCommands, code)
+	}
+
+	srvConfig.IdleTimeouts = make(map[uint16]time.Duration)
+	for command, timeout := range cfg.IdleTimeouts {
+		code, err := commandCode(command)
+		if err != nil {
+			return server.Config{}, err
+		}
+		if timeout.Duration < 0 {
+			return server.Config{}, fmt.Errorf("invalid idle timeout %v for %s", timeout.Duration, command)
+		}
+		srvConfig.IdleTimeouts[code] = timeout.Duration
+	}
+	return srvConfig, nil
+}
+
+func commandCode(command string) (uint16, error) {
+	switch command {
+	case commandConnect:
+		return command_request.CONNECT, nil
+	case commandBind:
+		return command_request.BIND, nil
+	case commandUdpAssociate:
+		return command_request.UDP_ASSOCIATE, nil
+	default:
+		return 0, fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// Parses the idle timeouts of the commands, i.e. "connect=5m,udp_associate=2m"
+func parseIdleTimeouts(value string) (map[string]duration, error) {
+	timeouts := make(map[string]duration)
+	for _, item := range splitList(value) {
+		command, timeout, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid idle timeout %q, expected command=duration", item)
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(timeout))
+		if err != nil {
+			return nil, fmt.Errorf("invalid idle timeout %q: %w", item, err)
+		}
+		timeouts[strings.TrimSpace(command)] = duration{parsed}
+	}
+	return timeouts, nil
+}
+
+// Returns nil for an empty value
+func parseOptionalIP(name string, value string) (net.IP, error) {
+	if value == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return ip, nil
+}
+
+// Parses a single port or an inclusive range, i.e. "40000-40100". An empty value means an ephemeral port.
+func parsePortRange(value string) (proxies.PortRange, error) {
+	if value == "" {
+		return proxies.PortRange{}, nil
+	}
+	first, last, isRange := strings.Cut(value, "-")
+	if !isRange {
+		last = first
+	}
+	firstPort, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+	if err != nil {
+		return proxies.PortRange{}, fmt.Errorf("invalid port range %q", value)
+	}
+	lastPort, err := strconv.ParseUint(strings.TrimSpace(last), 10, 16)
+	if err != nil || firstPort == 0 || firstPort > lastPort {
+		return proxies.PortRange{}, fmt.Errorf("invalid port range %q", value)
+	}
+	return proxies.PortRange{First: uint16(firstPort), Last: uint16(lastPort)}, nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+	return parsed, nil
+}
+
+// Splits comma separated flag values, i.e. "connect,bind"
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
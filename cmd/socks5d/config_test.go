@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"socks5_server/messages/requests/command_request"
+	"socks5_server/server"
+	"socks5_server/server/proxies"
+	"testing"
+	"time"
+)
+
+func Test_ParseArgs_Flags_Override_Config_File(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "socks5d.json")
+	credentialsPath := filepath.Join(dir, "credentials")
+	configFile := `{"listen": "0.0.0.0:1080", "auth_methods": ["username_password", "none"], "credentials_file": "` + credentialsPath + `", "dial_timeout": "3s", "allowed_commands": ["connect", "bind"]}`
+	if err := os.WriteFile(configPath, []byte(configFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(credentialsPath, []byte("# comment\nuser:pass:word\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := parseArgs([]string{"-config", configPath, "-listen", "127.0.0.1:1081", "-commands", "connect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:1081" {
+		t.Fatalf("Expected the flag to override listen, got %v", cfg.Listen)
+	}
+	if cfg.DialTimeout.Duration != 3*time.Second {
+		t.Fatalf("Expected dial timeout from the config file, got %v", cfg.DialTimeout)
+	}
+	if cfg.LogLevel != "info" {
+		t.Fatalf("Expected default log level, got %v", cfg.LogLevel)
+	}
+
+	srvConfig, err := cfg.serverConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srvConfig.Authenticators) != 2 {
+		t.Fatalf("Expected 2 authenticators, got %v", len(srvConfig.Authenticators))
+	}
+	userPass, ok := srvConfig.Authenticators[0].(*server.UsernamePasswordAuthenticator)
+	if !ok {
+		t.Fatalf("Expected username_password to be preferred, got %T", srvConfig.Authenticators[0])
+	}
+	if !userPass.Credentials.Valid("user", "pass:word") {
+		t.Fatal("Expected credentials from the credentials file")
+	}
+	if len(srvConfig.AllowedCommands) != 1 || srvConfig.AllowedCommands[0] != command_request.CONNECT {
+		t.Fatalf("Expected only CONNECT to be allowed, got %v", srvConfig.AllowedCommands)
+	}
+}
+
+func Test_ServerConfig_Rejects_Invalid_Values(t *testing.T) {
+	invalid := [][]string{
+		{"-auth", "unknown"},
+		{"-auth", "username_password"},
+		{"-auth", ""},
+		{"-commands", "connect,unknown"},
+		{"-source-address", "not-an-ip"},
+		{"-bind-ip", "not-an-ip"},
+		{"-bind-external-ip", "not-an-ip"},
+		{"-bind-ports", "40100-40000"},
+		{"-bind-ports", "0-100"},
+		{"-bind-ports", "http"},
+		{"-udp-fragments", "keep"},
+		{"-idle-timeouts", "resolve=5m"},
+		{"-idle-timeouts", "connect=-5m"},
+	}
+	for _, args := range invalid {
+		cfg, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cfg.serverConfig(nil); err == nil {
+			t.Fatalf("Expected error for %v", args)
+		}
+	}
+}
+
+func Test_ParsePortRange(t *testing.T) {
+	values := []string{"", "40000", "40000-40100"}
+	expected := []proxies.PortRange{{}, {First: 40000, Last: 40000}, {First: 40000, Last: 40100}}
+	for i, value := range values {
+		ports, err := parsePortRange(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ports != expected[i] {
+			t.Fatalf("Expected %v for %q, got %v", expected[i], value, ports)
+		}
+	}
+}
+
+func Test_ParseArgs_Idle_Timeouts(t *testing.T) {
+	cfg, err := parseArgs([]string{"-idle-timeouts", "connect=5m, udp_associate=2m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvConfig, err := cfg.serverConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[uint16]time.Duration{command_request.CONNECT: 5 * time.Minute, command_request.UDP_ASSOCIATE: 2 * time.Minute}
+	if len(srvConfig.IdleTimeouts) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, srvConfig.IdleTimeouts)
+	}
+	for command, timeout := range expected {
+		if srvConfig.IdleTimeouts[command] != timeout {
+			t.Fatalf("Expected %v, got %v", expected, srvConfig.IdleTimeouts)
+		}
+	}
+
+	for _, value := range []string{"connect", "connect=5"} {
+		if _, err := parseArgs([]string{"-idle-timeouts", value}); err == nil {
+			t.Fatalf("Expected error for %q", value)
+		}
+	}
+}
+
+func Test_ParseArgs_YAML_Config_File(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "socks5d.yaml")
+	configFile := "listen: 0.0.0.0:1082\nauth_methods: [none]\ndial_timeout: 3s\nallowed_commands:\n  - connect\nidle_timeouts:\n  connect: 5m\n"
+	if err := os.WriteFile(configPath, []byte(configFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := parseArgs([]string{"-config", configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "0.0.0.0:1082" || cfg.DialTimeout.Duration != 3*time.Second || cfg.IdleTimeouts["connect"].Duration != 5*time.Minute {
+		t.Fatalf("Expected the settings of the YAML file, got %+v", cfg)
+	}
+	if cfg.LogLevel != "info" {
+		t.Fatalf("Expected default log level, got %v", cfg.LogLevel)
+	}
+
+	if err := os.WriteFile(configPath, []byte("listen: 0.0.0.0:1082\nunknown: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseArgs([]string{"-config", configPath}); err == nil {
+		t.Fatal("Expected unknown fields to be rejected")
+	}
+}
@@ -0,0 +1,153 @@
+// Command socks5d runs the socks5 server.
+//
+// The configuration is read from an optional YAML or JSON file (-config, chosen by the .yaml/.yml or .json extension) and every setting can be overridden with a flag:
+//
+//	socks5d -listen 0.0.0.0:1080 -auth username_password -credentials /etc/socks5d/credentials
+//
+// On SIGINT or SIGTERM the server stops accepting clients and waits up to -shutdown-timeout for the active sessions to finish.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"socks5_server/server"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "socks5d:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return err
+	}
+
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	srvConfig, err := cfg.serverConfig(logger)
+	if err != nil {
+		return err
+	}
+	srv := server.NewServer(srvConfig)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", cfg.Listen)
+		serveErr <- srv.ListenAndServe(cfg.Listen)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig.String(), "timeout", cfg.ShutdownTimeout.Duration)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	stats := srv.UDPFragmentStats()
+	logger.Info("udp fragments", "reassembled", stats.Reassembled.Load(), "dropped", stats.Dropped.Load(), "out_of_order", stats.OutOfOrder.Load(), "expired", stats.Expired.Load())
+	if err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, server.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Builds the config from the defaults, the config file and the flags, in increasing order of priority
+func parseArgs(args []string) (config, error) {
+	flags := flag.NewFlagSet("socks5d", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML (.yaml, .yml) or JSON config file")
+	listen := flags.String("listen", "", "address to listen on, i.e. 0.0.0.0:1080")
+	authMethods := flags.String("auth", "", "comma separated auth methods in order of preference: "+authMethodNone+", "+authMethodUsernamePassword)
+	credentialsFile := flags.String("credentials", "", "file with username:password per line, required by "+authMethodUsernamePassword)
+	dialTimeout := flags.Duration("dial-timeout", 0, "timeout for connecting to the remote server for CONNECT")
+	keepAlive := flags.Duration("keep-alive", 0, "keep-alive period of the outbound TCP connections, negative disables keep-alives")
+	sourceAddress := flags.String("source-address", "", "source IP of the outbound connections, chosen by the system if empty")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 0, "time given to the active sessions to finish on shutdown")
+	bindIP := flags.String("bind-ip", "", "IP on which the BIND listeners are opened, defaults to the IP the client connected to")
+	bindPorts := flags.String("bind-ports", "", "port or range of ports for the BIND listeners, i.e. 40000-40100, defaults to an ephemeral port")
+	bindExternalIP := flags.String("bind-external-ip", "", "IP reported to the clients for the BIND listeners, i.e. when behind NAT")
+	bindTimeout := flags.Duration("bind-timeout", 0, "time given to the expected peer to connect to a BIND listener, negative means no timeout")
+	udpFragments := flags.String("udp-fragments", "", "what the UDP relay does with fragmented datagrams: "+udpFragmentsDrop+" or "+udpFragmentsReassemble)
+	idleTimeouts := flags.String("idle-timeouts", "", "comma separated idle timeouts of the commands, i.e. connect=5m,udp_associate=2m, commands without one are never ended for being idle")
+	allowedCommands := flags.String("commands", "", "comma separated allowed commands: "+strings.Join([]string{commandConnect, commandBind, commandUdpAssociate}, ", "))
+	maxConnections := flags.Int("max-connections", 0, "maximum number of concurrent sessions, 0 means unlimited")
+	logLevel := flags.String("log-level", "", "one of debug, info, warn, error")
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile != "" {
+		if err := loadConfigFile(*configFile, &cfg); err != nil {
+			return config{}, err
+		}
+	}
+
+	// only the flags which were explicitly set override the config file
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "auth":
+			cfg.AuthMethods = splitList(*authMethods)
+		case "credentials":
+			cfg.CredentialsFile = *credentialsFile
+		case "dial-timeout":
+			cfg.DialTimeout = duration{*dialTimeout}
+		case "keep-alive":
+			cfg.KeepAlive = duration{*keepAlive}
+		case "source-address":
+			cfg.SourceAddress = *sourceAddress
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = duration{*shutdownTimeout}
+		case "bind-ip":
+			cfg.BindIP = *bindIP
+		case "bind-ports":
+			cfg.BindPorts = *bindPorts
+		case "bind-external-ip":
+			cfg.BindExternalIP = *bindExternalIP
+		case "bind-timeout":
+			cfg.BindTimeout = duration{*bindTimeout}
+		case "udp-fragments":
+			cfg.UDPFragments = *udpFragments
+		case "idle-timeouts":
+			cfg.IdleTimeouts, err = parseIdleTimeouts(*idleTimeouts)
+		case "commands":
+			cfg.AllowedCommands = splitList(*allowedCommands)
+		case "max-connections":
+			cfg.MaxConnections = *maxConnections
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		}
+	})
+	if err != nil {
+		return config{}, err
+	}
+	if cfg.ShutdownTimeout.Duration < 0 {
+		return config{}, fmt.Errorf("invalid shutdown timeout %v", cfg.ShutdownTimeout.Duration)
+	}
+	return cfg, nil
+}
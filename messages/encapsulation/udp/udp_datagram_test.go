@@ -18,9 +18,9 @@ func Test_UDPDatagram_Deserialization(t *testing.T) {
 		{0x00, 0x00, 0x00, shared.ATYP_FQDN, 0x0b, 0x69, 0x66, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x6d, 0x65, 0x00, 0x50, 0xFF, 0x01, 0x02, 0x03},
 	}
 	expected := []UDPDatagram{
-		{Frag: 0, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
-		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
-		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 0, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
 		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF}},
 		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF, 0x01, 0x02, 0x03, 0x03}},
 		{Frag: 88, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF, 0x01, 0x02, 0x03, 0x03}},
@@ -75,9 +75,9 @@ func Test_UDPDatagram_Deserialize_with_invalid_RSV(t *testing.T) {
 }
 func Test_UDPDatagram_ToBytes(t *testing.T) {
 	datagrams := []UDPDatagram{
-		{Frag: 0, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
-		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
-		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 0, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
+		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535, DATA: []byte{0x00}},
 		{Frag: 255, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF}},
 		{Frag: 127, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF, 0x01, 0x02, 0x03, 0x03}},
 		{Frag: 88, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80, DATA: []byte{0xFF, 0x01, 0x02, 0x03, 0x03}},
@@ -38,3 +38,9 @@ type InvalidAtypError struct {
 func (e *InvalidAtypError) Error() string {
 	return fmt.Sprintf("Invalid Atyp: %d", e.Atyp)
 }
+
+type MismatchedSubnegotiationVersionError struct{}
+
+func (e MismatchedSubnegotiationVersionError) Error() string {
+	return "Mismatched sub-negotiation version"
+}
@@ -27,7 +27,7 @@ func (m *AvailableAuthMethods) Methods() []uint16 {
 
 // AddMethod Checks if given method is valid and if so, it appends it to any other methods already presented in the instance
 func (m *AvailableAuthMethods) AddMethod(method uint16) error {
-	if method > shared.JsonParameterBlock || method == shared.Unassigned {
+	if !shared.IsValidAuthMethod(method) {
 		return messages.UnknownAuthMethodError{Method: method}
 	}
 	m.methods = append(m.methods, method)
@@ -56,6 +56,9 @@ func TestAvailableAuthMethods_Deserialize_MustThrowErrorIfProtocolVersionIsNot5(
 func TestAvailableAuthMethods_Deserialize_MustThrowErrorIfMethodIsUnknown(t *testing.T) {
 	reqWithInvalidAuthType := []byte{0x05, 0x01, 0x10}
 	for i := 10; i <= 255; i++ {
+		if i >= 0x80 && i <= 0xFE {
+			continue // private methods
+		}
 		reqWithInvalidAuthType[2] = byte(i)
 		msg := AvailableAuthMethods{}
 		err := msg.Deserialize(reqWithInvalidAuthType)
@@ -65,6 +68,19 @@ func TestAvailableAuthMethods_Deserialize_MustThrowErrorIfMethodIsUnknown(t *tes
 	}
 }
 
+func TestAvailableAuthMethods_Deserialize_Private_Methods(t *testing.T) {
+	for method := uint16(0x80); method <= 0xFE; method++ {
+		msg := AvailableAuthMethods{}
+		err := msg.Deserialize(getCorrectBytes([]uint16{method}))
+		if err != nil {
+			t.Fatal("Failed to deserialize private method", method, err)
+		}
+		if msg.Methods()[0] != method {
+			t.Fatal("Expected", method, "but got", msg.Methods()[0])
+		}
+	}
+}
+
 func TestAvailableAuthMethods_ToBytes_Single(t *testing.T) {
 	validMethods := []uint16{0, 1, 2, 3, 5, 6, 7, 8, 9}
 	for i := range validMethods {
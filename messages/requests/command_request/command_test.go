@@ -11,7 +11,7 @@ import (
 func Test_CommandRequest_Deserialize_With_IPv6(t *testing.T) {
 	requestIps := [][]byte{{0x20, 0x01, 0x00, 0x00, 0x13, 0x0f, 0x00, 0x00, 0x00, 0x00, 0x09, 0xC0, 0x87, 0x6a, 0x13, 0x0b}, {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}}
 	requestedPorts := [][]byte{{0xFF, 0xFF}, {0x00, 0x50}}
-	expectedIps := []string{"2001:0000:130f:0000:0000:09c0:876a:130b", "0000:0000:0000:0000:0000:0000:0000:0001"}
+	expectedIps := []string{"2001:0:130f::9c0:876a:130b", "::1"}
 	expectedPorts := []uint16{65535, 80}
 	requestTypes := []byte{CONNECT, BIND, UDP_ASSOCIATE}
 	for _, requestType := range requestTypes {
@@ -209,9 +209,9 @@ func Test_CommandRequest_ToBytes(t *testing.T) {
 		{CMD: CONNECT, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80},
 		{CMD: BIND, DST_ADDR: shared.DstAddr{Value: "65.65.65.65", Type: shared.ATYP_IPV4}, DST_PORT: 65535},
 		{CMD: UDP_ASSOCIATE, DST_ADDR: shared.DstAddr{Value: "127.0.0.1", Type: shared.ATYP_IPV4}, DST_PORT: 80},
-		{CMD: CONNECT, DST_ADDR: shared.DstAddr{Value: "0000:0000:0000:0000:0000:0000:0000:0001", Type: shared.ATYP_IPV6}, DST_PORT: 80},
-		{CMD: BIND, DST_ADDR: shared.DstAddr{Value: "2001:0000:130f:0000:0000:09c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535},
-		{CMD: UDP_ASSOCIATE, DST_ADDR: shared.DstAddr{Value: "0000:0000:0000:0000:0000:0000:0000:0001", Type: shared.ATYP_IPV6}, DST_PORT: 80},
+		{CMD: CONNECT, DST_ADDR: shared.DstAddr{Value: "::1", Type: shared.ATYP_IPV6}, DST_PORT: 80},
+		{CMD: BIND, DST_ADDR: shared.DstAddr{Value: "2001:0:130f::9c0:876a:130b", Type: shared.ATYP_IPV6}, DST_PORT: 65535},
+		{CMD: UDP_ASSOCIATE, DST_ADDR: shared.DstAddr{Value: "::1", Type: shared.ATYP_IPV6}, DST_PORT: 80},
 		{CMD: CONNECT, DST_ADDR: shared.DstAddr{Value: "google.com", Type: shared.ATYP_FQDN}, DST_PORT: 80},
 		{CMD: BIND, DST_ADDR: shared.DstAddr{Value: "google.com", Type: shared.ATYP_FQDN}, DST_PORT: 65535},
 		{CMD: UDP_ASSOCIATE, DST_ADDR: shared.DstAddr{Value: "google.com", Type: shared.ATYP_FQDN}, DST_PORT: 80},
@@ -0,0 +1,12 @@
+package username_password_auth
+
+import "fmt"
+
+type InvalidCredentialLengthError struct {
+	Field  string
+	Length int
+}
+
+func (e InvalidCredentialLengthError) Error() string {
+	return fmt.Sprintf("Invalid %s length: %d", e.Field, e.Length)
+}
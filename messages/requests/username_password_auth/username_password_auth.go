@@ -0,0 +1,89 @@
+package username_password_auth
+
+// Implements the sub-negotiation request send by the client once the server has chosen the Username/Password authentication method.
+// The message is defined in RFC1929 and it is not part of the RFC1928 messages, as such it has its own version field.
+import (
+	"socks5_server/messages"
+)
+
+// SUBNEGOTIATION_VERSION is the version of the sub-negotiation as defined in RFC1929. It is not related to the socks protocol version.
+const SUBNEGOTIATION_VERSION byte = 0x01
+
+const (
+	messageVersionIndex  = 0
+	messageUsernameIndex = 1
+	maxCredentialLength  = 255
+)
+
+// UsernamePasswordAuth Represents the credentials send by the CLIENT. The fields are private, because their length is limited by the protocol and the setters ensure it.
+type UsernamePasswordAuth struct {
+	username string
+	password string
+}
+
+// Username is Getter for a field in the UsernamePasswordAuth struct.
+func (m *UsernamePasswordAuth) Username() string {
+	return m.username
+}
+
+// Password is Getter for a field in the UsernamePasswordAuth struct.
+func (m *UsernamePasswordAuth) Password() string {
+	return m.password
+}
+
+// SetUsername Checks if the username fits in the ULEN field and if so sets it
+func (m *UsernamePasswordAuth) SetUsername(username string) error {
+	if len(username) < 1 || len(username) > maxCredentialLength {
+		return InvalidCredentialLengthError{Field: "username", Length: len(username)}
+	}
+	m.username = username
+	return nil
+}
+
+// SetPassword Checks if the password fits in the PLEN field and if so sets it
+func (m *UsernamePasswordAuth) SetPassword(password string) error {
+	if len(password) < 1 || len(password) > maxCredentialLength {
+		return InvalidCredentialLengthError{Field: "password", Length: len(password)}
+	}
+	m.password = password
+	return nil
+}
+
+// ToBytes Converts the structure into wire-transferable data
+func (m *UsernamePasswordAuth) ToBytes() []byte {
+	req := make([]byte, 0, 3+len(m.username)+len(m.password))
+	req = append(req, SUBNEGOTIATION_VERSION, byte(len(m.username)))
+	req = append(req, m.username...)
+	req = append(req, byte(len(m.password)))
+	req = append(req, m.password...)
+	return req
+}
+
+// Deserialize Constructs UsernamePasswordAuth from bytes transferred over the wire
+func (m *UsernamePasswordAuth) Deserialize(buf []byte) error {
+	if len(buf) < 5 { // ver+ulen+uname+plen+passwd is at least 5 bytes
+		return messages.MalformedMessageError{}
+	}
+	if buf[messageVersionIndex] != SUBNEGOTIATION_VERSION {
+		return messages.MismatchedSubnegotiationVersionError{}
+	}
+
+	usernameLength := int(buf[messageUsernameIndex])
+	passwordLengthIndex := messageUsernameIndex + 1 + usernameLength
+	if passwordLengthIndex >= len(buf) {
+		return messages.MalformedMessageError{}
+	}
+	passwordLength := int(buf[passwordLengthIndex])
+	passwordEndIndex := passwordLengthIndex + 1 + passwordLength
+	if passwordEndIndex > len(buf) {
+		return messages.MalformedMessageError{}
+	}
+
+	if err := m.SetUsername(string(buf[messageUsernameIndex+1 : passwordLengthIndex])); err != nil {
+		return messages.MalformedMessageError{}
+	}
+	if err := m.SetPassword(string(buf[passwordLengthIndex+1 : passwordEndIndex])); err != nil {
+		return messages.MalformedMessageError{}
+	}
+	return nil
+}
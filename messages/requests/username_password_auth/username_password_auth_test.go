@@ -0,0 +1,97 @@
+package username_password_auth
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getCorrectBytes(username string, password string) []byte {
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	return append(req, password...)
+}
+
+func Test_UsernamePasswordAuth_ToBytes(t *testing.T) {
+	credentials := [][]string{{"user", "pass"}, {"a", "b"}, {strings.Repeat("u", 255), strings.Repeat("p", 255)}}
+	for _, credential := range credentials {
+		msg := UsernamePasswordAuth{}
+		if err := msg.SetUsername(credential[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := msg.SetPassword(credential[1]); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg.ToBytes(), getCorrectBytes(credential[0], credential[1])) {
+			t.Fatal("Expected", getCorrectBytes(credential[0], credential[1]), "but got", msg.ToBytes())
+		}
+	}
+}
+
+func Test_UsernamePasswordAuth_Deserialize(t *testing.T) {
+	credentials := [][]string{{"user", "pass"}, {"a", "b"}, {strings.Repeat("u", 255), strings.Repeat("p", 255)}}
+	for _, credential := range credentials {
+		msg := UsernamePasswordAuth{}
+		err := msg.Deserialize(getCorrectBytes(credential[0], credential[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Username() != credential[0] || msg.Password() != credential[1] {
+			t.Fatalf("Expected %v, got %v:%v", credential, msg.Username(), msg.Password())
+		}
+	}
+}
+
+func Test_UsernamePasswordAuth_Setters_MustRejectInvalidLength(t *testing.T) {
+	invalid := []string{"", strings.Repeat("a", 256)}
+	for _, value := range invalid {
+		msg := UsernamePasswordAuth{}
+		if err := msg.SetUsername(value); err == nil {
+			t.Fatalf("Expected error for username with length %d", len(value))
+		}
+		if err := msg.SetPassword(value); err == nil {
+			t.Fatalf("Expected error for password with length %d", len(value))
+		}
+	}
+}
+
+func Test_UsernamePasswordAuth_Deserialize_MustThrowErrorIfVersionIsNot1(t *testing.T) {
+	req := getCorrectBytes("user", "pass")
+	for i := 0; i < 255; i++ {
+		if i == 1 {
+			continue
+		}
+		req[0] = byte(i)
+		msg := UsernamePasswordAuth{}
+		if err := msg.Deserialize(req); err == nil {
+			t.Fatal("Expected error but got nil", i)
+		}
+	}
+}
+
+func Test_UsernamePasswordAuth_Deserialize_MustThrowErrorIfTruncated(t *testing.T) {
+	req := getCorrectBytes("user", "pass")
+	for i := 0; i < len(req); i++ {
+		msg := UsernamePasswordAuth{}
+		if err := msg.Deserialize(req[:i]); err == nil {
+			t.Fatal("Expected error but got nil for length", i)
+		}
+	}
+}
+
+func Fuzz_UsernamePasswordAuth_Deserialize(f *testing.F) {
+	f.Add(getCorrectBytes("user", "pass"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := UsernamePasswordAuth{}
+		err := msg.Deserialize(data)
+		if err != nil && !isKnownError(err) {
+			t.Fatalf("Unexpected error %v with data %+v", err, data)
+		}
+	})
+}
+
+func isKnownError(err error) bool {
+	return strings.Contains(err.Error(), "Mismatched sub-negotiation version") ||
+		strings.Contains(err.Error(), "Message is malformed")
+}
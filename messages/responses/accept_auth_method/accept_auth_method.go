@@ -15,12 +15,9 @@ func (aam *AcceptAuthMethod) Method() uint16 {
 }
 
 func (aam *AcceptAuthMethod) SetMethod(method uint16) error {
-	if method != shared.NoAcceptableMethods && method > shared.JsonParameterBlock {
+	if method != shared.NoAcceptableMethods && !shared.IsValidAuthMethod(method) {
 		return messages.UnknownAuthMethodError{Method: method}
 	}
-	if method == shared.Unassigned {
-		return messages.UnknownAuthMethodError{Method: shared.Unassigned}
-	}
 	aam.method = method
 	return nil
 }
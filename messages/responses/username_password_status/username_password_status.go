@@ -0,0 +1,38 @@
+package username_password_status
+
+// This package provides the message, with which the server responds to the Username/Password sub-negotiation defined in RFC1929.
+// Any status other than Success means the client must close the connection.
+import (
+	"socks5_server/messages"
+	"socks5_server/messages/requests/username_password_auth"
+)
+
+// Statuses as defined in RFC1929. The RFC only defines 0x00 as success, any other value is a failure.
+const (
+	Success = 0x00
+	Failure = 0x01
+)
+
+type UsernamePasswordStatus struct {
+	Status uint16
+}
+
+// IsSuccessful reports whether the server accepted the credentials
+func (s *UsernamePasswordStatus) IsSuccessful() bool {
+	return s.Status == Success
+}
+
+func (s *UsernamePasswordStatus) ToBytes() []byte {
+	return []byte{username_password_auth.SUBNEGOTIATION_VERSION, byte(s.Status)}
+}
+
+func (s *UsernamePasswordStatus) Deserialize(buf []byte) error {
+	if len(buf) < 2 {
+		return messages.MalformedMessageError{}
+	}
+	if buf[0] != username_password_auth.SUBNEGOTIATION_VERSION {
+		return messages.MismatchedSubnegotiationVersionError{}
+	}
+	s.Status = uint16(buf[1])
+	return nil
+}
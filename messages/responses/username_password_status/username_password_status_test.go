@@ -0,0 +1,40 @@
+package username_password_status
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_UsernamePasswordStatus_ToBytes(t *testing.T) {
+	statuses := []UsernamePasswordStatus{{Status: Success}, {Status: Failure}, {Status: 0xFF}}
+	expected := [][]byte{{0x01, 0x00}, {0x01, 0x01}, {0x01, 0xFF}}
+	for i, status := range statuses {
+		if !bytes.Equal(status.ToBytes(), expected[i]) {
+			t.Errorf("Expected: %v, Got: %v", expected[i], status.ToBytes())
+		}
+	}
+}
+
+func Test_UsernamePasswordStatus_Deserialize(t *testing.T) {
+	statuses := [][]byte{{0x01, 0x00}, {0x01, 0x01}, {0x01, 0xFF}}
+	expectedSuccess := []bool{true, false, false}
+	for i, status := range statuses {
+		msg := UsernamePasswordStatus{}
+		if err := msg.Deserialize(status); err != nil {
+			t.Fatalf("Unexpected error when deserializing status: %v", err)
+		}
+		if msg.IsSuccessful() != expectedSuccess[i] {
+			t.Errorf("Expected: %v, Got: %v", expectedSuccess[i], msg.IsSuccessful())
+		}
+	}
+}
+
+func Test_UsernamePasswordStatus_Deserialize_MustThrowErrorOnInvalidMessage(t *testing.T) {
+	invalid := [][]byte{{}, {0x01}, {0x05, 0x00}}
+	for _, msgBytes := range invalid {
+		msg := UsernamePasswordStatus{}
+		if err := msg.Deserialize(msgBytes); err == nil {
+			t.Errorf("Expected error for %v", msgBytes)
+		}
+	}
+}
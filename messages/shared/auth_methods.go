@@ -18,3 +18,18 @@ const (
 	JsonParameterBlock  = 9
 	NoAcceptableMethods = 255
 )
+
+// The range X'80' to X'FE' is reserved for private methods as defined in RFC1928
+const (
+	PrivateMethodsStart = 0x80
+	PrivateMethodsEnd   = 0xFE
+)
+
+// IsValidAuthMethod reports whether the method is either assigned by IANA or in the range reserved for private methods.
+// NoAcceptableMethods is not a valid method, because it can only be send by the server.
+func IsValidAuthMethod(method uint16) bool {
+	if method >= PrivateMethodsStart && method <= PrivateMethodsEnd {
+		return true
+	}
+	return method <= JsonParameterBlock && method != Unassigned
+}
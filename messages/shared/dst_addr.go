@@ -11,8 +11,8 @@ const messageFqdnLengthIndex = 1
 const ipv4Format = "%d.%d.%d.%d"
 const ipv4Size = 4
 
-const ipv6Format = "%x:%x:%x:%x:%x:%x:%x:%x"
 const ipv6Size = 16
+const maxFqdnSize = 255
 
 // Provides name->int mapping for the different address types as defined in RFC1928
 const (
@@ -34,9 +34,29 @@ type DstAddr struct {
 	Value string
 }
 
+// NewDstAddr creates DstAddr with the address type chosen from the value: IPv4 and IPv6 literals use ATYP_IPV4 and ATYP_IPV6, anything else is treated as FQDN
+func NewDstAddr(value string) DstAddr {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return DstAddr{Value: value, Type: ATYP_FQDN}
+	}
+	return NewDstAddrFromIP(ip)
+}
+
+// NewDstAddrFromIP creates DstAddr from IP, using ATYP_IPV4 whenever the IP can be represented as IPv4 (including IPv4-mapped IPv6 addresses)
+func NewDstAddrFromIP(ip net.IP) DstAddr {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return DstAddr{Value: ipv4.String(), Type: ATYP_IPV4}
+	}
+	return DstAddr{Value: ip.String(), Type: ATYP_IPV6}
+}
+
 func (addr *DstAddr) ToBytes() ([]byte, error) {
 
 	if addr.Type == ATYP_FQDN {
+		if len(addr.Value) == 0 || len(addr.Value) > maxFqdnSize {
+			return nil, errors.New("invalid fqdn length " + addr.Value)
+		}
 		bin := make([]byte, 0)
 		bin = append(bin, byte(len(addr.Value)))
 		bin = append(bin, addr.Value...)
@@ -48,10 +68,17 @@ func (addr *DstAddr) ToBytes() ([]byte, error) {
 		return nil, errors.New("invalid ip address " + addr.Value)
 	}
 
-	if addr.Type == ATYP_IPV6 {
+	switch addr.Type {
+	case ATYP_IPV4:
+		ipv4 := ip.To4()
+		if ipv4 == nil {
+			return nil, errors.New("not an ipv4 address " + addr.Value)
+		}
+		return ipv4, nil
+	case ATYP_IPV6:
 		return ip.To16(), nil
 	}
-	return ip.To4(), nil
+	return nil, UnknownATYP{AddrType: addr.Type}
 }
 
 func (addr *DstAddr) Deserialize(buf []byte, addrType uint16) (int, error) {
@@ -65,7 +92,7 @@ func (addr *DstAddr) Deserialize(buf []byte, addrType uint16) (int, error) {
 		return ipv4Size, nil
 	case ATYP_FQDN:
 		fqdnSize := int(buf[0])
-		if fqdnSize > len(buf) {
+		if messageFqdnLengthIndex+fqdnSize > len(buf) {
 			return 0, messages.MalformedMessageError{}
 		}
 		addr.Value = string(buf[messageFqdnLengthIndex : messageFqdnLengthIndex+fqdnSize])
@@ -74,7 +101,7 @@ func (addr *DstAddr) Deserialize(buf []byte, addrType uint16) (int, error) {
 		if len(buf) < 16 {
 			return 0, messages.MalformedMessageError{}
 		}
-		addr.Value = fmt.Sprintf(ipv6Format, buf[0:2], buf[2:4], buf[4:6], buf[6:8], buf[8:10], buf[10:12], buf[12:14], buf[14:16])
+		addr.Value = net.IP(buf[:ipv6Size]).String()
 		return ipv6Size, nil
 	}
 	return 0, UnknownATYP{AddrType: addrType}
@@ -1,6 +1,9 @@
 package shared
 
-import "testing"
+import (
+	"net"
+	"testing"
+)
 
 func Test_DstAddr_Must_Deserialize_IpV4(t *testing.T) {
 	ipsAsBytes := [][]byte{{0x7F, 0x00, 0x00, 0x01}, {0x7B, 0x7B, 0x7B, 0x7B}, {0x00, 0x00, 0x00, 0x00}, {0xFF, 0xFF, 0xFF, 0xFF}}
@@ -40,9 +43,19 @@ func Test_DstAddr_Must_Deserialize_FQDN(t *testing.T) {
 
 }
 
+func Test_DstAddr_Must_Reject_Truncated_FQDN(t *testing.T) {
+	truncated := [][]byte{{0x04, 0x61, 0x62, 0x63}, {0xFF, 0x61, 0x62, 0x63}}
+	for i := range truncated {
+		dstAddr := DstAddr{}
+		if _, err := dstAddr.Deserialize(truncated[i], ATYP_FQDN); err == nil {
+			t.Fatal("Expected error for", truncated[i])
+		}
+	}
+}
+
 func Test_DstAddr_Must_Deserialize_IpV6(t *testing.T) {
 	ipsAsBytes := [][]byte{{0x20, 0x01, 0x00, 0x00, 0x13, 0x0f, 0x00, 0x00, 0x00, 0x00, 0x09, 0xC0, 0x87, 0x6a, 0x13, 0x0b}, {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}}
-	expectedIps := []string{"2001:0000:130f:0000:0000:09c0:876a:130b", "0000:0000:0000:0000:0000:0000:0000:0001"}
+	expectedIps := []string{"2001:0:130f::9c0:876a:130b", "::1"}
 	for i := range ipsAsBytes {
 		dstAddr := DstAddr{}
 		addrSize, err := dstAddr.Deserialize(ipsAsBytes[i], ATYP_IPV6)
@@ -58,6 +71,48 @@ func Test_DstAddr_Must_Deserialize_IpV6(t *testing.T) {
 	}
 }
 
+func Test_NewDstAddr_Must_Choose_Type_From_Value(t *testing.T) {
+	values := []string{"127.0.0.1", "::1", "2001:0:130f::9c0:876a:130b", "::ffff:10.0.0.1", "localhost", "example.com"}
+	expectedTypes := []uint16{ATYP_IPV4, ATYP_IPV6, ATYP_IPV6, ATYP_IPV4, ATYP_FQDN, ATYP_FQDN}
+	expectedValues := []string{"127.0.0.1", "::1", "2001:0:130f::9c0:876a:130b", "10.0.0.1", "localhost", "example.com"}
+	for i := range values {
+		dstAddr := NewDstAddr(values[i])
+		if dstAddr.Type != expectedTypes[i] {
+			t.Fatal("Expected type", expectedTypes[i], "for", values[i], ", got", dstAddr.Type)
+		}
+		if dstAddr.Value != expectedValues[i] {
+			t.Fatal("Expected", expectedValues[i], ", got", dstAddr.Value)
+		}
+	}
+}
+
+func Test_DstAddr_IpV6_Must_Survive_Round_Trip(t *testing.T) {
+	ips := []string{"::1", "fe80::1", "2001:db8::8a2e:370:7334"}
+	for _, ip := range ips {
+		dstAddr := NewDstAddrFromIP(net.ParseIP(ip))
+		bytes, err := dstAddr.ToBytes()
+		if err != nil {
+			t.Fatalf("Got error: %v", err)
+		}
+		deserialized := DstAddr{}
+		if _, err := deserialized.Deserialize(bytes, ATYP_IPV6); err != nil {
+			t.Fatalf("Got error: %v", err)
+		}
+		if deserialized.Value != ip {
+			t.Fatal("Expected", ip, ", got", deserialized.Value)
+		}
+	}
+}
+
+func Test_DstAddr_ToBytes_Must_Reject_Mismatched_Type(t *testing.T) {
+	invalid := []DstAddr{{Value: "::1", Type: ATYP_IPV4}, {Value: "localhost", Type: ATYP_IPV6}, {Value: "", Type: ATYP_FQDN}, {Value: "127.0.0.1", Type: 0x02}}
+	for _, dstAddr := range invalid {
+		if _, err := dstAddr.ToBytes(); err == nil {
+			t.Fatal("Expected error for", dstAddr)
+		}
+	}
+}
+
 func Benchmark_DstAddr_Must_Deserialize_FQDN(b *testing.B) {
 	fqdn := []byte{0x09, 0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x68, 0x6f, 0x73, 0x74}
 	for i := 0; i < b.N; i++ {
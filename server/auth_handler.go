@@ -12,30 +12,61 @@ func (session *Session) handleAuth() {
 	n, err := session.conn.Read(authMethodCandidate)
 	if err != nil {
 		session.setError(err)
+		return
 	}
 
 	authMethods := available_auth_methods.AvailableAuthMethods{}
 	err = authMethods.Deserialize(authMethodCandidate[:n])
 	if err != nil {
 		session.setError(err)
+		return
 	}
 
 	msg := accept_auth_method.AcceptAuthMethod{}
-	if slices.Contains(authMethods.Methods(), shared.NoAuthRequired) {
-		err := msg.SetMethod(shared.NoAuthRequired)
-		if err != nil {
-			session.setError(err)
-		}
+	session.authenticator = session.chooseAuthenticator(authMethods.Methods())
+	if session.authenticator != nil {
+		err = msg.SetMethod(session.authenticator.Method())
 	} else {
 		err = msg.SetMethod(shared.NoAcceptableMethods)
-		if err != nil {
-			session.setError(err)
-		}
+	}
+	if err != nil {
+		session.setError(err)
+		return
 	}
 
 	_, err = session.conn.Write(msg.ToBytes())
 	if err != nil {
 		session.setError(err)
+		return
+	}
+
+	if session.authenticator == nil {
+		// RFC1928: if the selected METHOD is X'FF', the client MUST close the connection. We don't rely on it.
+		session.close()
+		return
+	}
+	session.state = PendingAuthentication
+}
+
+// Returns the first authenticator, in the order of preference of the server, whose method is offered by the client.
+func (session *Session) chooseAuthenticator(offered []uint16) Authenticator {
+	for _, authenticator := range session.server.Config.authenticators() {
+		if slices.Contains(offered, authenticator.Method()) {
+			return authenticator
+		}
+	}
+	return nil
+}
+
+// Executes the sub-negotiation of the chosen method. Each method is responsible for notifying the client about failures,
+// as such the session is only closed here.
+func (session *Session) handleAuthentication() {
+	identity, err := session.authenticator.Authenticate(session.conn)
+	if err != nil {
+		session.err = err
+		session.close()
+		return
 	}
+	session.identity = identity
 	session.state = Authenticated
 }
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"socks5_server/messages/requests/username_password_auth"
+	"socks5_server/messages/responses/username_password_status"
+	"socks5_server/messages/shared"
+)
+
+// Authenticator implements a single authentication method. The server advertises the methods of its authenticators
+// and picks the first one, in its own order of preference, which is also offered by the client.
+type Authenticator interface {
+	// Method returns the METHOD value used during negotiation, including private methods in the X'80'-X'FE' range
+	Method() uint16
+	// Authenticate executes the method-specific sub-negotiation over conn. Returning an error terminates the session.
+	Authenticate(conn net.Conn) (Identity, error)
+}
+
+// Identity describes the client as established by the Authenticator
+type Identity struct {
+	Method   uint16
+	Username string
+}
+
+// NoAuthAuthenticator accepts every client without sub-negotiation
+type NoAuthAuthenticator struct{}
+
+func (NoAuthAuthenticator) Method() uint16 {
+	return shared.NoAuthRequired
+}
+
+func (NoAuthAuthenticator) Authenticate(conn net.Conn) (Identity, error) {
+	return Identity{Method: shared.NoAuthRequired}, nil
+}
+
+// UsernamePasswordAuthenticator executes the Username/Password sub-negotiation as defined in RFC1929 and validates the credentials against the Credentials store
+type UsernamePasswordAuthenticator struct {
+	Credentials CredentialStore
+}
+
+func (authenticator *UsernamePasswordAuthenticator) Method() uint16 {
+	return shared.UsernameAndPassword
+}
+
+func (authenticator *UsernamePasswordAuthenticator) Authenticate(conn net.Conn) (Identity, error) {
+	credentialsCandidate := make([]byte, 1024)
+	n, err := conn.Read(credentialsCandidate)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	credentials := username_password_auth.UsernamePasswordAuth{}
+	status := username_password_status.UsernamePasswordStatus{Status: username_password_status.Failure}
+	err = credentials.Deserialize(credentialsCandidate[:n])
+	if err != nil {
+		_, _ = conn.Write(status.ToBytes())
+		return Identity{}, err
+	}
+
+	if authenticator.Credentials.Valid(credentials.Username(), credentials.Password()) {
+		status.Status = username_password_status.Success
+	}
+	_, err = conn.Write(status.ToBytes())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if !status.IsSuccessful() {
+		return Identity{}, InvalidCredentialsError{Username: credentials.Username()}
+	}
+	return Identity{Method: shared.UsernameAndPassword, Username: credentials.Username()}, nil
+}
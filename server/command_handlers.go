@@ -1,8 +1,8 @@
 package server
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"net"
 	"socks5_server/messages/requests/command_request"
 	"socks5_server/messages/responses/command_response"
@@ -15,12 +15,19 @@ func (session *Session) handleCommand() {
 	n, err := session.conn.Read(commandCandidate)
 	if err != nil {
 		session.setError(err)
+		return
 	}
 
 	cmd := command_request.CommandRequest{}
 	err = cmd.Deserialize(commandCandidate[:n])
 	if err != nil {
 		session.setError(err)
+		return
+	}
+
+	if !session.server.Config.isCommandAllowed(cmd.CMD) {
+		session.rejectCommand(command_response.ConnectionNotAllowedByRuleSet, CommandNotAllowedError{Command: cmd.CMD})
+		return
 	}
 
 	switch cmd.CMD {
@@ -31,7 +38,7 @@ func (session *Session) handleCommand() {
 		session.handleBindCmd(cmd)
 		return
 	case command_request.UDP_ASSOCIATE:
-		session.handleUdpAssociateCmd()
+		session.handleUdpAssociateCmd(cmd)
 		return
 	default:
 		session.setError(errors.New("unknown command"))
@@ -39,67 +46,145 @@ func (session *Session) handleCommand() {
 	}
 }
 func (session *Session) handleConnectCmd(cmd command_request.CommandRequest) {
-	proxyErrors := make(chan error)
-	remoteAddr := fmt.Sprintf("%s:%d", cmd.DST_ADDR.Value, cmd.DST_PORT)
-	proxy, err := proxies.NewConnectProxy(remoteAddr, session.conn)
+	ips, err := session.resolve(cmd.DST_ADDR)
 	if err != nil {
-		session.setError(err)
+		session.rejectCommand(replyStatusFromError(err), err)
+		return
 	}
-	go proxy.Start(proxyErrors)
+	// bounds the custom dialers as well, whose attempts aren't limited by NetDialer.Timeout
+	ctx, cancel := context.WithTimeout(session.ctx, session.server.Config.dialTimeout())
+	defer cancel()
+	proxy, err := proxies.NewConnectProxy(ctx, ips, cmd.DST_PORT, session.conn, session.server.Config.dialer(), session.server.Config.idleTimeout(cmd.CMD))
 	if err != nil {
-		session.setError(err)
+		session.rejectCommand(replyStatusFromError(err), err)
+		return
 	}
-	go session.proxyErrorHandler(proxyErrors, proxy)
+	session.server.Config.logger().Debug("connected to destination", "client", session.conn.RemoteAddr().String(), "destination", proxy.RemoteIp.String())
 
 	resp := command_response.CommandResponse{}
 	resp.Status = command_response.Success
-	resp.BND_PORT = 0
-	resp.BND_ADDR = shared.DstAddr{Value: "0.0.0.0", Type: shared.ATYP_IPV4}
-	bytes, _ := resp.ToBytes()
-	session.conn.Write(bytes)
-	session.state = Proxying
+	resp.BND_PORT = proxy.LocalPort
+	resp.BND_ADDR = shared.NewDstAddrFromIP(proxy.LocalIp)
+	session.replyAndStartProxy(resp, proxy)
 }
-func (session *Session) handleUdpAssociateCmd() {
-	proxyErrors := make(chan error)
-	proxy, err := proxies.NewUDPProxy()
+func (session *Session) handleUdpAssociateCmd(cmd command_request.CommandRequest) {
+	proxy, err := proxies.NewUDPProxy(session.conn, session.udpClientAddr(cmd), session.server.Config.resolver(), session.server.Config.packetListener(), session.server.Config.UDPFragments, &session.server.udpFragmentStats, session.server.Config.idleTimeout(cmd.CMD))
 	if err != nil {
+		session.rejectCommand(replyStatusFromError(err), err)
 		return
 	}
-	go proxy.Start(proxyErrors)
 
 	resp := command_response.CommandResponse{}
 	resp.Status = command_response.Success
 	resp.BND_PORT = proxy.Port
-	resp.BND_ADDR = shared.DstAddr{Value: session.conn.LocalAddr().(*net.TCPAddr).IP.String(), Type: shared.ATYP_IPV4}
-	bytes, _ := resp.ToBytes()
-	session.conn.Write(bytes)
-	session.state = Proxying
-	go session.proxyErrorHandler(proxyErrors, proxy)
+	resp.BND_ADDR = shared.NewDstAddrFromIP(session.conn.LocalAddr().(*net.TCPAddr).IP)
+	session.replyAndStartProxy(resp, proxy)
+}
+
+// Returns the address from which the client will send its datagrams. DST.ADDR and DST.PORT are used when the client knows them,
+// otherwise (all zeros, or a hostname) the IP of the TCP connection is used and the port isn't restricted, as RFC1928 allows.
+func (session *Session) udpClientAddr(cmd command_request.CommandRequest) *net.UDPAddr {
+	clientIp := session.conn.RemoteAddr().(*net.TCPAddr).IP
+	if cmd.DST_ADDR.Type != shared.ATYP_FQDN {
+		if ip := net.ParseIP(cmd.DST_ADDR.Value); ip != nil && !ip.IsUnspecified() {
+			clientIp = ip
+		}
+	}
+	return &net.UDPAddr{IP: clientIp, Port: int(cmd.DST_PORT)}
 }
+
 func (session *Session) handleBindCmd(cmd command_request.CommandRequest) {
-	proxyErrors := make(chan error)
-	remoteAddr := fmt.Sprintf("%s:%d", cmd.DST_ADDR.Value, cmd.DST_PORT)
-	proxy, err := proxies.NewBindProxy(session.conn, remoteAddr)
+	expectedPeers, err := session.resolve(cmd.DST_ADDR)
 	if err != nil {
-		session.setError(err)
+		session.rejectCommand(replyStatusFromError(err), err)
+		return
 	}
-	go proxy.Start(proxyErrors)
+	config := &session.server.Config
+	localIp := session.conn.LocalAddr().(*net.TCPAddr).IP
+	listenIp := config.BindIP
+	if listenIp == nil {
+		listenIp = localIp
+	}
+	proxy, err := proxies.NewBindProxy(session.conn, listenIp, config.BindPorts, expectedPeers, config.bindAcceptTimeout(), config.idleTimeout(cmd.CMD))
 	if err != nil {
-		session.setError(err)
+		session.rejectCommand(replyStatusFromError(err), err)
+		return
 	}
-	go session.proxyErrorHandler(proxyErrors, proxy)
 
+	// the reply must hold an address the peer can connect to, which the unspecified address isn't
+	reportedIp := config.BindExternalIP
+	if reportedIp == nil {
+		reportedIp = proxy.ListeningIp
+		if reportedIp.IsUnspecified() {
+			reportedIp = localIp
+		}
+	}
 	resp := command_response.CommandResponse{}
 	resp.Status = command_response.Success
 	resp.BND_PORT = proxy.ListeningPort
-	resp.BND_ADDR = shared.DstAddr{Value: proxy.ListeningIp, Type: shared.ATYP_IPV4}
-	bytes, _ := resp.ToBytes()
-	session.conn.Write(bytes)
+	resp.BND_ADDR = shared.NewDstAddrFromIP(reportedIp)
+	session.replyAndStartProxy(resp, proxy)
+}
+
+// Returns the IPs of DST.ADDR, FQDN addresses are resolved with the configured Resolver
+func (session *Session) resolve(addr shared.DstAddr) ([]net.IP, error) {
+	if addr.Type != shared.ATYP_FQDN {
+		ip := net.ParseIP(addr.Value)
+		if ip == nil {
+			return nil, &net.AddrError{Err: "invalid IP address", Addr: addr.Value}
+		}
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(session.ctx, session.server.Config.dialTimeout())
+	defer cancel()
+	return session.server.Config.resolver().LookupIP(ctx, addr.Value)
+}
+
+// Sends the successful reply and only then starts the proxy, so no data from the remote side can reach the client before the reply
+func (session *Session) replyAndStartProxy(resp command_response.CommandResponse, proxy proxies.Proxy) {
+	bytes, err := resp.ToBytes()
+	if err != nil {
+		proxy.Stop()
+		session.setError(err)
+		return
+	}
+	_, err = session.conn.Write(bytes)
+	if err != nil {
+		proxy.Stop()
+		session.setError(err)
+		return
+	}
+	session.startProxy(proxy)
 	session.state = Proxying
 }
 
-func (session *Session) proxyErrorHandler(errors chan error, proxy proxies.Proxy) {
-	err := <-errors
+// Starts the proxy in the background, the session is terminated by proxyErrorHandler once the proxy reports completion.
+// The channel is buffered, because a proxy may report from each direction of the data flow and only the first report is read.
+func (session *Session) startProxy(proxy proxies.Proxy) {
+	session.mu.Lock()
+	session.proxy = proxy
+	session.mu.Unlock()
+	session.proxyErrors = make(chan error, 2)
+	go proxy.Start(session.proxyErrors)
+}
+
+// Blocks until the proxy reports completion (nil) or an error, after which the proxy and the session are closed
+func (session *Session) proxyErrorHandler() {
+	err := <-session.proxyErrors
 	session.setError(err)
-	proxy.Stop()
+	session.mu.Lock()
+	session.proxy.Stop()
+	session.mu.Unlock()
+}
+
+// Responds to the command with the given status and terminates the session
+func (session *Session) rejectCommand(status uint16, err error) {
+	resp := command_response.CommandResponse{}
+	resp.Status = status
+	resp.BND_PORT = 0
+	resp.BND_ADDR = shared.DstAddr{Value: "0.0.0.0", Type: shared.ATYP_IPV4}
+	bytes, _ := resp.ToBytes()
+	session.conn.Write(bytes)
+	session.err = err
+	session.close()
 }
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"slices"
+	"socks5_server/messages/requests/command_request"
+	"socks5_server/server/dialers"
+	"socks5_server/server/proxies"
+	"socks5_server/server/resolvers"
+	"time"
+)
+
+// DefaultDialTimeout is used for the outbound connections when Config.DialTimeout is not set
+const DefaultDialTimeout = time.Duration(1) * time.Second
+
+// DefaultBindAcceptTimeout is used by the BIND command when Config.BindAcceptTimeout is not set
+const DefaultBindAcceptTimeout = time.Duration(2) * time.Minute
+
+// Config holds the configuration of a Server. The zero value is valid: only No Auth clients are accepted, all commands are allowed
+// and there is no limit on the number of connections.
+type Config struct {
+	// Authenticators are used to negotiate the auth method, in order of preference. Defaults to NoAuthAuthenticator.
+	Authenticators []Authenticator
+	// DialTimeout is the timeout for resolving the target of the CONNECT command and for connecting to it, including all attempts.
+	// It bounds the context given to Dialer.DialContext, so it applies to a custom Dialer as well. Defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+	// Dialer opens the connections to the CONNECT targets. When it implements dialers.PacketListener, it also opens the outbound
+	// sockets of the UDP relays, otherwise they are opened by dialers.NetDialer. Defaults to dialers.NetDialer with DialTimeout as its timeout.
+	Dialer dialers.Dialer
+	// AllowedCommands is the list of commands served by the server. Any other command is rejected with ConnectionNotAllowedByRuleSet. Defaults to all commands.
+	AllowedCommands []uint16
+	// Logger receives the errors of the listener and the sessions. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Resolver resolves the FQDN addresses of CONNECT and BIND requests and of UDP datagrams. Defaults to resolvers.NetResolver, which uses the system resolver.
+	Resolver resolvers.Resolver
+	// BindIP is the IP on which the listeners of the BIND command are opened. Defaults to the IP on which the client reached the server.
+	BindIP net.IP
+	// BindPorts limits the ports of the BIND listeners, i.e. to a range opened in the firewall. Defaults to an ephemeral port.
+	BindPorts proxies.PortRange
+	// BindExternalIP is reported to the client as the address of the BIND listener instead of the IP it listens on,
+	// for servers reachable on a different address, i.e. behind NAT.
+	BindExternalIP net.IP
+	// BindAcceptTimeout is the time given to the expected peer to connect to the BIND listener, after which the BIND fails
+	// with TtlExpired and the listener is closed. Defaults to DefaultBindAcceptTimeout, negative means no timeout.
+	BindAcceptTimeout time.Duration
+	// IdleTimeouts holds the idle timeout of each command (command_request.CONNECT, BIND and UDP_ASSOCIATE): the session ends once
+	// no data is proxied in either direction for that long. Commands without a positive timeout are never ended for being idle.
+	IdleTimeouts map[uint16]time.Duration
+	// UDPFragments decides what the UDP relays do with fragmented datagrams. Defaults to proxies.FragmentsDrop,
+	// the outcome is counted in Server.UDPFragmentStats.
+	UDPFragments proxies.FragmentPolicy
+	// MaxConnections limits the number of concurrent sessions. When the limit is reached, the server stops accepting until a session finishes. Zero means no limit.
+	MaxConnections int
+}
+
+func (config *Config) authenticators() []Authenticator {
+	if len(config.Authenticators) == 0 {
+		return []Authenticator{NoAuthAuthenticator{}}
+	}
+	return config.Authenticators
+}
+
+func (config *Config) dialTimeout() time.Duration {
+	if config.DialTimeout <= 0 {
+		return DefaultDialTimeout
+	}
+	return config.DialTimeout
+}
+
+func (config *Config) bindAcceptTimeout() time.Duration {
+	if config.BindAcceptTimeout < 0 {
+		return 0
+	}
+	if config.BindAcceptTimeout == 0 {
+		return DefaultBindAcceptTimeout
+	}
+	return config.BindAcceptTimeout
+}
+
+func (config *Config) idleTimeout(cmd uint16) time.Duration {
+	return max(config.IdleTimeouts[cmd], 0)
+}
+
+func (config *Config) dialer() dialers.Dialer {
+	if config.Dialer == nil {
+		return dialers.NetDialer{Timeout: config.dialTimeout()}
+	}
+	return config.Dialer
+}
+
+// The UDP relay needs an unconnected socket, which a Dialer that doesn't implement dialers.PacketListener can't open
+func (config *Config) packetListener() dialers.PacketListener {
+	if listener, ok := config.dialer().(dialers.PacketListener); ok {
+		return listener
+	}
+	return dialers.NetDialer{}
+}
+
+func (config *Config) resolver() resolvers.Resolver {
+	if config.Resolver == nil {
+		return resolvers.NetResolver{}
+	}
+	return config.Resolver
+}
+
+func (config *Config) isCommandAllowed(cmd uint16) bool {
+	if config.AllowedCommands == nil {
+		return cmd == command_request.CONNECT || cmd == command_request.BIND || cmd == command_request.UDP_ASSOCIATE
+	}
+	return slices.Contains(config.AllowedCommands, cmd)
+}
+
+func (config *Config) logger() *slog.Logger {
+	if config.Logger == nil {
+		return slog.Default()
+	}
+	return config.Logger
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CredentialStore validates the credentials send by the client during the Username/Password sub-negotiation (RFC1929).
+// Implementations must be safe for concurrent use, because every session calls Valid from its own goroutine.
+type CredentialStore interface {
+	Valid(username string, password string) bool
+}
+
+// StaticCredentials is a CredentialStore backed by an in-memory username->password map
+type StaticCredentials map[string]string
+
+func (credentials StaticCredentials) Valid(username string, password string) bool {
+	expected, ok := credentials[username]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// LoadCredentialsFile reads StaticCredentials from a file with one `username:password` pair per line.
+// Empty lines and lines starting with # are ignored. The password may contain `:`, the username may not.
+func LoadCredentialsFile(path string) (StaticCredentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	credentials := StaticCredentials{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, password, found := strings.Cut(line, ":")
+		if !found || username == "" || password == "" {
+			return nil, fmt.Errorf("%s:%d: expected username:password", path, lineNumber)
+		}
+		credentials[username] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
@@ -0,0 +1,63 @@
+package dialers
+
+// Dialers used by the server for the outbound connections (CONNECT targets and the outbound sockets of the UDP relay).
+import (
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Dialer opens the outbound connections of the server. Implementations must be safe for concurrent use.
+// Replacing it allows chaining the server through another proxy or injecting fakes in tests.
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// PacketListener opens the unconnected UDP socket of a UDP relay, on which the datagrams of any remote are received.
+// A Dialer which implements it is used for the UDP relay as well.
+type PacketListener interface {
+	ListenPacket(ctx context.Context, network string, address string) (net.PacketConn, error)
+}
+
+// NetDialer is the default Dialer and PacketListener, it uses net.Dialer and net.ListenConfig
+type NetDialer struct {
+	// Timeout limits each connection attempt. Zero means that only the context limits the attempt.
+	Timeout time.Duration
+	// KeepAlive is the keep-alive period of TCP connections. Zero uses the default of the standard library, negative disables keep-alives.
+	KeepAlive time.Duration
+	// LocalIP is the source address of the connections. When nil, the system chooses it.
+	LocalIP net.IP
+	// Control is called after creating the socket and before connecting, it allows setting socket options. See net.Dialer.Control.
+	Control func(network string, address string, c syscall.RawConn) error
+}
+
+func (dialer NetDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	netDialer := net.Dialer{Timeout: dialer.Timeout, KeepAlive: dialer.KeepAlive, Control: dialer.Control}
+	if dialer.LocalIP != nil {
+		netDialer.LocalAddr = localAddr(network, dialer.LocalIP)
+	}
+	return netDialer.DialContext(ctx, network, address)
+}
+
+// ListenPacket listens on address, on LocalIP instead of the host of address when LocalIP is set. Timeout and KeepAlive don't apply.
+func (dialer NetDialer) ListenPacket(ctx context.Context, network string, address string) (net.PacketConn, error) {
+	if dialer.LocalIP != nil {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		address = net.JoinHostPort(dialer.LocalIP.String(), port)
+	}
+	listenConfig := net.ListenConfig{Control: dialer.Control}
+	return listenConfig.ListenPacket(ctx, network, address)
+}
+
+// Returns the source address of the type expected by net.Dialer for the network
+func localAddr(network string, ip net.IP) net.Addr {
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.TCPAddr{IP: ip}
+}
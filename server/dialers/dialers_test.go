@@ -0,0 +1,70 @@
+package dialers
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func Test_NetDialer_Must_Bind_Source_Address_And_Call_Control(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	controlCalled := false
+	dialer := NetDialer{
+		LocalIP: net.ParseIP("127.0.0.1"),
+		Control: func(network string, address string, c syscall.RawConn) error {
+			controlCalled = true
+			return nil
+		},
+	}
+	conn, err := dialer.DialContext(context.Background(), "tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !controlCalled {
+		t.Fatal("Expected Control to be called")
+	}
+	if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal("Expected source address 127.0.0.1, got", ip)
+	}
+}
+
+func Test_NetDialer_Must_Bind_Source_Address_For_UDP(t *testing.T) {
+	dialer := NetDialer{LocalIP: net.ParseIP("127.0.0.1")}
+	conn, err := dialer.DialContext(context.Background(), "udp", "127.0.0.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.UDPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatal("Expected source address 127.0.0.1, got", ip)
+	}
+}
+
+func Test_NetDialer_Must_Listen_On_Source_Address_And_Call_Control(t *testing.T) {
+	controlCalled := false
+	dialer := NetDialer{
+		LocalIP: net.ParseIP("127.0.0.1"),
+		Control: func(network string, address string, c syscall.RawConn) error {
+			controlCalled = true
+			return nil
+		},
+	}
+	conn, err := dialer.ListenPacket(context.Background(), "udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !controlCalled {
+		t.Fatal("Expected Control to be called")
+	}
+	if addr := conn.LocalAddr().(*net.UDPAddr); !addr.IP.Equal(net.ParseIP("127.0.0.1")) || addr.Port == 0 {
+		t.Fatal("Expected an ephemeral port on 127.0.0.1, got", addr)
+	}
+}
@@ -12,7 +12,9 @@ func (session *Session) RespondToClientDependingOnState() {
 		noAcceptableMethodMsg := accept_auth_method.AcceptAuthMethod{}
 		noAcceptableMethodMsg.SetMethod(shared.NoAcceptableMethods)
 		session.conn.Write(noAcceptableMethodMsg.ToBytes())
-		session.conn.Close()
+		session.closeConn()
+	case PendingAuthentication:
+		session.closeConn()
 	case Authenticated:
 		srvFailure := command_response.CommandResponse{}
 		srvFailure.Status = command_response.SocksServerFailure
@@ -20,11 +22,11 @@ func (session *Session) RespondToClientDependingOnState() {
 		srvFailure.BND_PORT = 0
 		srvFailureBytes, err := srvFailure.ToBytes()
 		if err != nil {
-			session.conn.Close()
+			session.closeConn()
 		}
 		session.conn.Write(srvFailureBytes)
 	}
 	if session.state == Proxying {
-		session.conn.Close()
+		session.closeConn()
 	}
 }
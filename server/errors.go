@@ -0,0 +1,19 @@
+package server
+
+import "fmt"
+
+type InvalidCredentialsError struct {
+	Username string
+}
+
+func (e InvalidCredentialsError) Error() string {
+	return fmt.Sprintf("invalid credentials for user %q", e.Username)
+}
+
+type CommandNotAllowedError struct {
+	Command uint16
+}
+
+func (e CommandNotAllowedError) Error() string {
+	return fmt.Sprintf("command %d is not allowed", e.Command)
+}
@@ -1,61 +1,255 @@
 package proxies
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
+	"os"
 	"socks5_server/messages/responses/command_response"
 	"socks5_server/messages/shared"
+	"socks5_server/server/utils"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// ErrPortRangeExhausted is returned by NewBindProxy when every port of the range is in use
+var ErrPortRangeExhausted = errors.New("no free port in the bind port range")
+
+// PortRange is an inclusive range of ports. The zero value means an ephemeral port chosen by the system.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+func (ports PortRange) IsZero() bool {
+	return ports.First == 0 && ports.Last == 0
+}
+
 // A proxy which starts a listener and any accepted traffic is send to the client.
 // When a connection is accepted, the client is firstly notified about it via standard CommandResponse message
 // The ListeningPort and ListeningIp fields are used to return a message back to the client.
+// Only connections from the expected peers are accepted, the others are closed and the proxy keeps waiting until the accept timeout.
 type BindProxy struct {
-	server        net.Listener
+	server        *net.TCPListener
 	client        io.ReadWriteCloser
+	expectedPeers []net.IP
+	acceptTimeout time.Duration
+	idleTimeout   time.Duration
 	ListeningPort uint16
-	ListeningIp   string
+	ListeningIp   net.IP
+
+	mu      sync.Mutex // guards peer and stopped, as Stop is called from a goroutine different from the one accepting the peer
+	peer    net.Conn
+	stopped bool
 }
 
-func NewBindProxy(client io.ReadWriteCloser, addr string) (*BindProxy, error) {
-	listener, err := net.Listen("tcp", "127.0.0.1:8877")
+// NewBindProxy listens on listenIp, on a port from the range. Every BIND gets its own listener, so any number of them can be active at once.
+// expectedPeers are the IPs of DST.ADDR, an unspecified IP (0.0.0.0 or ::) allows any peer. The port of the peer isn't checked,
+// as it usually differs from DST.PORT, i.e. the FTP data connection doesn't come from the control port.
+// If no expected peer connects within acceptTimeout, the client is sent a failure reply. Zero means no timeout.
+// Once the peer is connected, the proxy ends when no data is transferred in either direction for idleTimeout, zero means no idle timeout.
+func NewBindProxy(client io.ReadWriteCloser, listenIp net.IP, ports PortRange, expectedPeers []net.IP, acceptTimeout time.Duration, idleTimeout time.Duration) (*BindProxy, error) {
+	listener, err := listenInRange(listenIp, ports)
 	if err != nil {
 		return nil, err
 	}
 
-	return &BindProxy{client: client, server: listener, ListeningPort: 8877, ListeningIp: "127.0.0.1"}, nil
+	addr := listener.Addr().(*net.TCPAddr)
+	return &BindProxy{client: client, server: listener, expectedPeers: expectedPeers, acceptTimeout: acceptTimeout, idleTimeout: idleTimeout, ListeningPort: uint16(addr.Port), ListeningIp: addr.IP}, nil
 }
 func (proxy *BindProxy) Start(errors chan error) error {
 	go func() {
-		in, err := proxy.server.Accept()
+		in, early, err := proxy.acceptWhileWatchingClient()
+		// the listener is only needed for a single connection
+		proxy.server.Close()
+		if isClientClosed(err) {
+			// there is no one left to notify, closing the connection is how the client ends the BIND
+			errors <- nil
+			return
+		}
 		if err != nil {
+			proxy.notifyClientAboutFailure(err)
 			errors <- err
 			return
 		}
+		if !proxy.setPeer(in) {
+			in.Close()
+			errors <- net.ErrClosed
+			return
+		}
 
 		err = proxy.notifyClientAboutIncomingConnection(in)
 		if err != nil {
 			errors <- err
 			return
 		}
+		if _, err := in.Write(early); err != nil {
+			errors <- err
+			return
+		}
 
-		SpliceConnections(in, proxy.client, errors)
+		SpliceConnections(proxy.client, in, utils.NewInactivityHandler(proxy.idleTimeout), errors)
 	}()
 
 	return nil
 }
 
+// Closes the listener, the client and the accepted peer, if there is one
 func (proxy *BindProxy) Stop() {
+	proxy.mu.Lock()
+	proxy.stopped = true
+	if proxy.peer != nil {
+		proxy.peer.Close()
+	}
+	proxy.mu.Unlock()
 	proxy.server.Close()
 	proxy.client.Close()
 }
+
+// Keeps the accepted peer for Stop. Returns false when the proxy was already stopped, the peer must be closed by the caller then.
+func (proxy *BindProxy) setPeer(in net.Conn) bool {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if proxy.stopped {
+		return false
+	}
+	proxy.peer = in
+	return true
+}
 func (proxy *BindProxy) notifyClientAboutIncomingConnection(in net.Conn) error {
-	addr := in.RemoteAddr().(*net.TCPAddr).IP.String()
+	addr := in.RemoteAddr().(*net.TCPAddr).IP
 	port := in.RemoteAddr().(*net.TCPAddr).Port
-	reqSourceMsg := command_response.CommandResponse{Status: command_response.Success, BND_ADDR: shared.DstAddr{Value: addr, Type: shared.ATYP_IPV4}, BND_PORT: uint16(port)}
+	reqSourceMsg := command_response.CommandResponse{Status: command_response.Success, BND_ADDR: shared.NewDstAddrFromIP(addr), BND_PORT: uint16(port)}
 
 	bytes, err := reqSourceMsg.ToBytes()
 	_, err = proxy.client.Write(bytes)
 
 	return err
 }
+
+// Waits for an expected peer while watching the client. The client sends nothing before the second reply, so its read returns only
+// once it closed the connection, which closes the listener. Anything the client sent meanwhile is returned with the peer, to be forwarded to it.
+// A client without read deadlines isn't watched, as its read couldn't be interrupted once the peer is accepted.
+func (proxy *BindProxy) acceptWhileWatchingClient() (net.Conn, []byte, error) {
+	deadliner, ok := proxy.client.(readDeadliner)
+	if !ok {
+		in, err := proxy.acceptExpectedPeer()
+		return in, nil, err
+	}
+	watched := make(chan clientWatch, 1)
+	go func() {
+		watched <- proxy.watchClient()
+	}()
+	in, err := proxy.acceptExpectedPeer()
+	// interrupt the watch, the client is read by the splice from now on
+	_ = deadliner.SetReadDeadline(time.Now())
+	watch := <-watched
+	if watch.err != nil {
+		if in != nil {
+			in.Close()
+		}
+		return nil, nil, watch.err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := deadliner.SetReadDeadline(time.Time{}); err != nil {
+		in.Close()
+		return nil, nil, err
+	}
+	return in, watch.early, nil
+}
+
+// The outcome of watching the client while waiting for the peer
+type clientWatch struct {
+	early []byte
+	err   error // wraps errClientClosed when the client closed the connection or its read failed
+}
+
+var errClientClosed = errors.New("client closed the connection before the peer connected")
+
+func isClientClosed(err error) bool {
+	return errors.Is(err, errClientClosed)
+}
+
+// Reads the client until the read is interrupted by its deadline. Closes the listener when the client closed the connection or its read failed.
+func (proxy *BindProxy) watchClient() clientWatch {
+	var early []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := proxy.client.Read(buf)
+		early = append(early, buf[:n]...)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return clientWatch{early: early}
+		}
+		if err != nil {
+			proxy.server.Close()
+			return clientWatch{err: fmt.Errorf("%w: %w", errClientClosed, err)}
+		}
+	}
+}
+
+// Accepts connections until one comes from an expected peer or the accept timeout expires
+func (proxy *BindProxy) acceptExpectedPeer() (net.Conn, error) {
+	if proxy.acceptTimeout > 0 {
+		if err := proxy.server.SetDeadline(time.Now().Add(proxy.acceptTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		in, err := proxy.server.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if proxy.isExpectedPeer(in.RemoteAddr().(*net.TCPAddr).IP) {
+			return in, nil
+		}
+		in.Close()
+	}
+}
+
+func (proxy *BindProxy) isExpectedPeer(ip net.IP) bool {
+	for _, expected := range proxy.expectedPeers {
+		if expected.IsUnspecified() || expected.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends the second reply with failure, TtlExpired when no expected peer has connected in time
+func (proxy *BindProxy) notifyClientAboutFailure(err error) {
+	status := uint16(command_response.SocksServerFailure)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		status = command_response.TtlExpired
+	}
+	reply := command_response.CommandResponse{Status: status, BND_ADDR: shared.DstAddr{Value: "0.0.0.0", Type: shared.ATYP_IPV4}}
+	bytes, _ := reply.ToBytes()
+	_, _ = proxy.client.Write(bytes)
+}
+
+// Listens on the first free port of the range, starting from a random one, so concurrent BINDs don't compete for the same ports
+func listenInRange(ip net.IP, ports PortRange) (*net.TCPListener, error) {
+	if ports.IsZero() {
+		return net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	}
+	if ports.First == 0 || ports.First > ports.Last {
+		return nil, fmt.Errorf("invalid bind port range %d-%d", ports.First, ports.Last)
+	}
+	size := int(ports.Last) - int(ports.First) + 1
+	offset := rand.IntN(size)
+	for i := 0; i < size; i++ {
+		port := int(ports.First) + (offset+i)%size
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip, Port: port})
+		if err == nil {
+			return listener, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, ErrPortRangeExhausted
+}
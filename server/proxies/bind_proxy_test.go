@@ -0,0 +1,127 @@
+package proxies
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Returns a port which was free at the time of the call
+func freePort(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func Test_ListenInRange_Must_Use_The_Range_Until_Exhausted(t *testing.T) {
+	port := freePort(t)
+	ports := PortRange{First: port, Last: port}
+	listener, err := listenInRange(net.ParseIP("127.0.0.1"), ports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listenerPort := uint16(listener.Addr().(*net.TCPAddr).Port); listenerPort != port {
+		t.Fatal("Expected port", port, ", got", listenerPort)
+	}
+
+	if _, err := listenInRange(net.ParseIP("127.0.0.1"), ports); !errors.Is(err, ErrPortRangeExhausted) {
+		t.Fatal("Expected ErrPortRangeExhausted, got", err)
+	}
+}
+
+func Test_ListenInRange_Must_Use_Ephemeral_Port_For_Zero_Range(t *testing.T) {
+	first, err := listenInRange(net.ParseIP("127.0.0.1"), PortRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := listenInRange(net.ParseIP("127.0.0.1"), PortRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if first.Addr().String() == second.Addr().String() {
+		t.Fatal("Expected distinct ephemeral ports, got", first.Addr())
+	}
+}
+
+func Test_ListenInRange_Must_Reject_Invalid_Range(t *testing.T) {
+	invalid := []PortRange{{First: 2000, Last: 1000}, {First: 0, Last: 1000}}
+	for _, ports := range invalid {
+		if _, err := listenInRange(net.ParseIP("127.0.0.1"), ports); err == nil {
+			t.Fatal("Expected error for", ports)
+		}
+	}
+}
+
+// Starts a BIND proxy for any peer on the loopback, the client side of the control connection is returned
+func startBindProxy(t *testing.T) (*BindProxy, *net.TCPConn, chan error) {
+	client, control := tcpPair(t)
+	t.Cleanup(func() {
+		client.Close()
+		control.Close()
+	})
+	proxy, err := NewBindProxy(control, net.ParseIP("127.0.0.1"), PortRange{}, []net.IP{net.IPv4zero}, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxy.Stop)
+	errors := make(chan error, 2)
+	if err := proxy.Start(errors); err != nil {
+		t.Fatal(err)
+	}
+	return proxy, client, errors
+}
+
+func Test_BindProxy_Must_Close_Listener_When_Client_Closes(t *testing.T) {
+	proxy, client, errors := startBindProxy(t)
+	client.Close()
+
+	select {
+	case err := <-errors:
+		if err != nil {
+			t.Fatal("Expected the BIND to end without error, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the BIND to end once the client closed the connection")
+	}
+	peerAddr := &net.TCPAddr{IP: proxy.ListeningIp, Port: int(proxy.ListeningPort)}
+	if peer, err := net.DialTCP("tcp", nil, peerAddr); err == nil {
+		peer.Close()
+		t.Fatal("Expected the listener to be closed")
+	}
+}
+
+func Test_BindProxy_Must_Forward_To_Peer_After_Watching_Client(t *testing.T) {
+	proxy, client, _ := startBindProxy(t)
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	// the client isn't supposed to send anything before the second reply, but it mustn't be lost
+	if _, err := client.Write([]byte("early ")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	peer, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: proxy.ListeningIp, Port: int(proxy.ListeningPort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	_ = peer.SetDeadline(time.Now().Add(5 * time.Second))
+	// the second reply for an IPv4 peer
+	if _, err := io.ReadFull(client, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("early late"))
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "early late" {
+		t.Fatalf("Expected the peer to receive the data of the client, got %q, err %v", buf, err)
+	}
+}
@@ -0,0 +1,104 @@
+package proxies
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ConnectionAttemptDelay is the time to wait for a connection attempt before starting the next one in parallel, as recommended by RFC8305
+const ConnectionAttemptDelay = time.Duration(250) * time.Millisecond
+
+type dialFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+type attemptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Connects to one of the IPs following the Happy Eyeballs algorithm (RFC8305). The attempts are started in the order of
+// sortForHappyEyeballs, the next one is started when the previous one fails or after attemptDelay, whichever happens first.
+// The first successful connection is returned and all other attempts are cancelled. If all attempts fail, the first error is returned.
+func dialHappyEyeballs(ctx context.Context, dial dialFunc, ips []net.IP, port uint16, attemptDelay time.Duration) (net.Conn, error) {
+	if len(ips) == 0 {
+		// a resolver may answer with an empty list, which is the same as a name without addresses
+		return nil, &net.DNSError{Err: "no addresses to connect to", IsNotFound: true}
+	}
+	ips = sortForHappyEyeballs(ips)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered, so the attempts which finish after the winner never block
+	results := make(chan attemptResult, len(ips))
+	next, pending := 0, 0
+	startNext := func() {
+		addr := net.JoinHostPort(ips[next].String(), strconv.Itoa(int(port)))
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, "tcp", addr)
+			results <- attemptResult{conn: conn, err: err}
+		}()
+	}
+
+	startNext()
+	timer := time.NewTimer(attemptDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				go closeLateConnections(results, pending)
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if next < len(ips) {
+				startNext()
+				timer.Reset(attemptDelay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				startNext()
+				timer.Reset(attemptDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// Closes the connections of the attempts which succeeded after the winner
+func closeLateConnections(results chan attemptResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if result := <-results; result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
+
+// Orders the IPs as described in RFC8305 section 4: the address families are interleaved, starting with IPv6.
+// The relative order of the IPs within a family is preserved.
+func sortForHappyEyeballs(ips []net.IP) []net.IP {
+	ipv6, ipv4 := make([]net.IP, 0), make([]net.IP, 0)
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ipv4 = append(ipv4, ip)
+		} else {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(ipv6) || i < len(ipv4); i++ {
+		if i < len(ipv6) {
+			sorted = append(sorted, ipv6[i])
+		}
+		if i < len(ipv4) {
+			sorted = append(sorted, ipv4[i])
+		}
+	}
+	return sorted
+}
@@ -0,0 +1,96 @@
+package proxies
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_SortForHappyEyeballs_Must_Interleave_Families_Starting_With_IPv6(t *testing.T) {
+	ips := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3"), net.ParseIP("::1"), net.ParseIP("::2")}
+	expected := []string{"::1", "10.0.0.1", "::2", "10.0.0.2", "10.0.0.3"}
+	sorted := sortForHappyEyeballs(ips)
+	if len(sorted) != len(expected) {
+		t.Fatal("Expected", len(expected), "IPs, got", len(sorted))
+	}
+	for i := range expected {
+		if sorted[i].String() != expected[i] {
+			t.Fatal("Expected", expected, ", got", sorted)
+		}
+	}
+}
+
+// Simulates the network: the IPs which aren't in reachable hang until the attempt is cancelled, the ones in refused fail immediately
+type fakeNetwork struct {
+	reachable map[string]bool
+	refused   map[string]bool
+
+	mu       sync.Mutex
+	attempts []string
+}
+
+func (network *fakeNetwork) dial(ctx context.Context, _ string, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	network.mu.Lock()
+	network.attempts = append(network.attempts, host)
+	network.mu.Unlock()
+	if network.refused[host] {
+		return nil, errors.New("refused")
+	}
+	if network.reachable[host] {
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func Test_DialHappyEyeballs_Must_Fall_Back_To_IPv4_After_Delay(t *testing.T) {
+	network := &fakeNetwork{reachable: map[string]bool{"127.0.0.1": true}}
+	ips := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("2001:db8::1")}
+	start := time.Now()
+	conn, err := dialHappyEyeballs(context.Background(), network.dial, ips, 80, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatal("Expected the IPv4 attempt to wait for the attempt delay, took", elapsed)
+	}
+	if network.attempts[0] != "2001:db8::1" {
+		t.Fatal("Expected IPv6 to be attempted first, got", network.attempts)
+	}
+}
+
+func Test_DialHappyEyeballs_Must_Start_Next_Attempt_Immediately_On_Failure(t *testing.T) {
+	network := &fakeNetwork{reachable: map[string]bool{"127.0.0.1": true}, refused: map[string]bool{"::1": true}}
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+	start := time.Now()
+	conn, err := dialHappyEyeballs(context.Background(), network.dial, ips, 80, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatal("Expected the failure to start the next attempt, took", elapsed)
+	}
+}
+
+func Test_DialHappyEyeballs_Must_Return_Error_When_All_Attempts_Fail(t *testing.T) {
+	network := &fakeNetwork{refused: map[string]bool{"::1": true, "127.0.0.1": true}}
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.1")}
+	if _, err := dialHappyEyeballs(context.Background(), network.dial, ips, 80, 50*time.Millisecond); err == nil {
+		t.Fatal("Expected error")
+	}
+	if len(network.attempts) != 2 {
+		t.Fatal("Expected both addresses to be attempted, got", network.attempts)
+	}
+	var dnsErr *net.DNSError
+	if _, err := dialHappyEyeballs(context.Background(), network.dial, nil, 80, 50*time.Millisecond); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatal("Expected a not found DNS error for no addresses, got", err)
+	}
+}
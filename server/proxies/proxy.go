@@ -1,21 +1,129 @@
 package proxies
 
-import "io"
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"socks5_server/server/utils"
+	"sync"
+	"time"
+)
 
+// Size of the buffers used to copy between connections which can't be spliced by the kernel, the same as io.Copy uses
+const spliceBufferSize = 32 * 1024
+
+// The buffers are shared by all tunnels, so a tunnel doesn't allocate a buffer per direction
+var spliceBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, spliceBufferSize)
+		return &buf
+	},
+}
+
+// Proxy transfers data between the client and the remote side. Start must report on the errors channel once the proxying is done,
+// either with the error which stopped it or with nil when the sides have closed their connections.
 type Proxy interface {
 	Start(errors chan error) error
 	Stop()
 }
 
-func SpliceConnections(client io.ReadWriter, server io.ReadWriter, errors chan error) {
+type closeWriter interface {
+	CloseWrite() error
+}
+
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// SpliceConnections copies the data in both directions and reports once on the errors channel. When one side reaches EOF,
+// the write side of the other is closed (half-close) and the copy in the opposite direction goes on until it ends as well,
+// so request/response protocols which shut down their write side, i.e. `nc -N`, still get the response.
+// A connection without CloseWrite can't be half-closed, so the first EOF ends the splice. An error in either direction ends it immediately.
+// The activity of both directions is recorded in idle, once neither has been active for its period the splice ends with idle.Err(). idle may be nil.
+func SpliceConnections(client io.ReadWriter, server io.ReadWriter, idle *utils.InactivityHandler, errors chan error) {
+	done := make(chan spliceResult, 2)
 	go func() {
-		_, err := io.Copy(server, client)
-		if err != nil {
-			errors <- err
-		}
+		done <- copyHalf(server, client, idle, utils.Upstream)
 	}()
-	_, err := io.Copy(client, server)
-	if err != nil {
-		errors <- err
+	go func() {
+		done <- copyHalf(client, server, idle, utils.Downstream)
+	}()
+
+	first := <-done
+	if first.err != nil || !first.halfClosed {
+		errors <- first.err
+		return
+	}
+	errors <- (<-done).err
+}
+
+type spliceResult struct {
+	err        error
+	halfClosed bool
+}
+
+// Copies src to dst until EOF and then closes the write side of dst, if it supports it
+func copyHalf(dst io.Writer, src io.Reader, idle *utils.InactivityHandler, direction utils.Direction) spliceResult {
+	if err := copyTrackingActivity(dst, src, idle, direction); err != nil {
+		return spliceResult{err: err}
+	}
+	closer, ok := dst.(closeWriter)
+	return spliceResult{halfClosed: ok && closer.CloseWrite() == nil}
+}
+
+// Copies src to dst until EOF. The activity is tracked with read deadlines rather than by wrapping the connections, which would
+// prevent splice(2): the copy is interrupted at every check of idle, the direction is active if any data was copied meanwhile.
+// A src without read deadlines is copied through a writer recording each write instead.
+func copyTrackingActivity(dst io.Writer, src io.Reader, idle *utils.InactivityHandler, direction utils.Direction) error {
+	if idle == nil {
+		_, err := copyConn(dst, src)
+		return err
+	}
+	deadliner, ok := src.(readDeadliner)
+	if !ok {
+		_, err := copyConn(activityWriter{dst, idle, direction}, src)
+		return err
+	}
+	for {
+		if err := deadliner.SetReadDeadline(idle.NextCheck()); err != nil {
+			return err
+		}
+		n, err := copyConn(dst, src)
+		if n > 0 {
+			idle.RecordActivityNow(direction)
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+		if idle.IsIdle() {
+			return idle.Err()
+		}
+	}
+}
+
+// Records the activity of the direction on every write
+type activityWriter struct {
+	io.Writer
+	idle      *utils.InactivityHandler
+	direction utils.Direction
+}
+
+func (writer activityWriter) Write(p []byte) (int, error) {
+	writer.idle.RecordActivityNow(writer.direction)
+	return writer.Writer.Write(p)
+}
+
+// Copies between two TCP connections with ReadFrom, which uses splice(2) on Linux so the data doesn't pass through user space.
+// Any other pair is copied with a buffer from the pool. ReadFrom and WriteTo are hidden from io.CopyBuffer in that case,
+// as their generic fallbacks would allocate their own buffer.
+func copyConn(dst io.Writer, src io.Reader) (int64, error) {
+	if dstConn, ok := dst.(*net.TCPConn); ok {
+		if srcConn, ok := src.(*net.TCPConn); ok {
+			return dstConn.ReadFrom(srcConn)
+		}
 	}
+	buf := spliceBuffers.Get().(*[]byte)
+	defer spliceBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
 }
@@ -0,0 +1,237 @@
+package proxies
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"socks5_server/server/utils"
+	"testing"
+	"time"
+)
+
+// Returns both ends of a loopback TCP connection
+func tcpPair(t testing.TB) (*net.TCPConn, *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	dialed, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := listener.AcceptTCP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialed, accepted
+}
+
+// Starts a tunnel between two TCP connections and returns the outer ends, the tunnel reports on the returned channel
+func startTcpTunnel(t testing.TB, idle *utils.InactivityHandler) (*net.TCPConn, *net.TCPConn, chan error) {
+	client, clientProxySide := tcpPair(t)
+	serverProxySide, server := tcpPair(t)
+	t.Cleanup(func() {
+		for _, conn := range []net.Conn{client, clientProxySide, serverProxySide, server} {
+			conn.Close()
+		}
+	})
+	errors := make(chan error, 2)
+	go SpliceConnections(clientProxySide, serverProxySide, idle, errors)
+	return client, server, errors
+}
+
+func Test_SpliceConnections_Must_Propagate_Half_Close(t *testing.T) {
+	client, server, errors := startTcpTunnel(t, nil)
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := client.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	request, err := io.ReadAll(server)
+	if err != nil || string(request) != "request" {
+		t.Fatalf("Expected the request followed by EOF, got %q, err %v", request, err)
+	}
+
+	// the server still answers after the client has shut down its write side
+	if _, err := server.Write([]byte("response")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errors:
+		t.Fatal("Expected the splice to go on after the half-close, got", err)
+	default:
+	}
+	server.Close()
+	response, err := io.ReadAll(client)
+	if err != nil || string(response) != "response" {
+		t.Fatalf("Expected the response followed by EOF, got %q, err %v", response, err)
+	}
+	if err := <-errors; err != nil {
+		t.Fatal("Expected the splice to report completion, got", err)
+	}
+}
+
+func Test_SpliceConnections_Must_End_On_EOF_Without_Half_Close(t *testing.T) {
+	// net.Pipe doesn't support CloseWrite, so the first EOF ends the splice
+	client, clientProxySide := net.Pipe()
+	serverProxySide, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	errors := make(chan error, 2)
+	go SpliceConnections(clientProxySide, serverProxySide, nil, errors)
+
+	client.Close()
+	select {
+	case err := <-errors:
+		if err != nil {
+			t.Fatal("Expected the splice to report completion, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the splice to end")
+	}
+}
+
+func Test_SpliceConnections_Must_End_Idle_Tunnels(t *testing.T) {
+	_, _, reports := startTcpTunnel(t, utils.NewInactivityHandler(100*time.Millisecond))
+	select {
+	case err := <-reports:
+		if !errors.Is(err, utils.ErrIdleTimeout) {
+			t.Fatal("Expected the idle timeout, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the idle tunnel to end")
+	}
+}
+
+func Test_SpliceConnections_Must_Keep_Tunnels_Active_In_One_Direction(t *testing.T) {
+	client, server, reports := startTcpTunnel(t, utils.NewInactivityHandler(200*time.Millisecond))
+	go func() {
+		_, _ = io.Copy(io.Discard, client)
+	}()
+	// only the server sends, for three times the idle timeout
+	for i := 0; i < 30; i++ {
+		if _, err := server.Write([]byte("tick")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case err := <-reports:
+		t.Fatal("Expected the tunnel to stay open while the server sends, got", err)
+	default:
+	}
+	select {
+	case err := <-reports:
+		if !errors.Is(err, utils.ErrIdleTimeout) {
+			t.Fatal("Expected the idle timeout once the server stopped sending, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the tunnel to end once idle")
+	}
+}
+
+func Test_SpliceConnections_Must_Copy_Large_Payloads(t *testing.T) {
+	client, server, _ := startTcpTunnel(t, nil)
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	go func() {
+		_, _ = client.Write(payload)
+		_ = client.CloseWrite()
+	}()
+	_ = server.SetDeadline(time.Now().Add(5 * time.Second))
+	received, err := io.ReadAll(server)
+	if err != nil || !bytes.Equal(received, payload) {
+		t.Fatalf("Expected %d bytes, got %d, err %v", len(payload), len(received), err)
+	}
+}
+
+// Throughput of a single tunnel between TCP connections, which are spliced by the kernel on Linux
+func BenchmarkSpliceConnections_TCP_Throughput(b *testing.B) {
+	benchmarkThroughput(b, func(b *testing.B) (io.WriteCloser, io.Reader) {
+		client, server, _ := startTcpTunnel(b, nil)
+		return client, server
+	})
+}
+
+// Like BenchmarkSpliceConnections_TCP_Throughput, with the activity tracked for an idle timeout
+func BenchmarkSpliceConnections_TCP_Throughput_With_Idle_Timeout(b *testing.B) {
+	benchmarkThroughput(b, func(b *testing.B) (io.WriteCloser, io.Reader) {
+		client, server, _ := startTcpTunnel(b, utils.NewInactivityHandler(time.Minute))
+		return client, server
+	})
+}
+
+// Throughput of a single tunnel between connections which can't be spliced, so the pooled buffers are used
+func BenchmarkSpliceConnections_Pipe_Throughput(b *testing.B) {
+	benchmarkThroughput(b, func(b *testing.B) (io.WriteCloser, io.Reader) {
+		client, clientProxySide := net.Pipe()
+		serverProxySide, server := net.Pipe()
+		b.Cleanup(func() {
+			client.Close()
+			server.Close()
+		})
+		errors := make(chan error, 2)
+		go SpliceConnections(clientProxySide, serverProxySide, nil, errors)
+		// a pipe can't be half-closed, so the remote side is closed once the splice reports, as the session does
+		go func() {
+			<-errors
+			serverProxySide.Close()
+		}()
+		return client, server
+	})
+}
+
+func benchmarkThroughput(b *testing.B, tunnel func(b *testing.B) (io.WriteCloser, io.Reader)) {
+	chunk := make([]byte, spliceBufferSize)
+	client, server := tunnel(b)
+	received := make(chan int64)
+	go func() {
+		n, _ := io.Copy(io.Discard, server)
+		received <- n
+	}()
+
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	client.Close()
+	if n := <-received; n != int64(b.N*len(chunk)) {
+		b.Fatalf("Expected %d bytes, got %d", b.N*len(chunk), n)
+	}
+}
+
+// Cost of a tunnel carrying a single request and response, which shows the allocations per tunnel
+func BenchmarkSpliceConnections_Per_Tunnel(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		// a pipe can't be half-closed, so closing one side ends the tunnel, new pipes are needed for each one
+		b.StopTimer()
+		clientProxySide, client := net.Pipe()
+		serverProxySide, server := net.Pipe()
+		b.StartTimer()
+		errors := make(chan error, 2)
+		go SpliceConnections(clientProxySide, serverProxySide, nil, errors)
+		go func() {
+			n, _ := server.Read(buf)
+			_, _ = server.Write(buf[:n])
+		}()
+		_, _ = client.Write([]byte("ping"))
+		_, _ = client.Read(buf)
+
+		client.Close()
+		<-errors
+		server.Close()
+		clientProxySide.Close()
+		serverProxySide.Close()
+	}
+}
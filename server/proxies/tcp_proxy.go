@@ -1,27 +1,61 @@
 package proxies
 
 import (
+	"context"
 	"io"
 	"net"
+	"socks5_server/server/dialers"
+	"socks5_server/server/utils"
+	"strconv"
 	"time"
 )
 
+// A proxy which connects to the remote server and splices the connection with the client.
+// The LocalIp and LocalPort fields hold the address used by the proxy for the connection to the remote server, they are returned to the client as BND.ADDR and BND.PORT.
 type TCPProxy struct {
-	server io.ReadWriteCloser
-	client io.ReadWriteCloser
+	server      io.ReadWriteCloser
+	client      io.ReadWriteCloser
+	idleTimeout time.Duration
+	LocalIp     net.IP
+	LocalPort   uint16
+	RemoteIp    net.IP
 }
 
-func NewConnectProxy(addr string, client io.ReadWriteCloser) (*TCPProxy, error) {
-	server, err := net.DialTimeout("tcp", addr, time.Duration(1)*time.Second)
+// NewConnectProxy connects to one of the IPs with the dialer using Happy Eyeballs (RFC8305), so an unreachable address family only delays
+// the connection by ConnectionAttemptDelay. RemoteIp holds the IP which won, LocalIp and LocalPort the address of the winning connection.
+// ctx bounds the whole dial, including all attempts. The tunnel ends once no data is transferred in either direction for idleTimeout,
+// zero means no idle timeout.
+func NewConnectProxy(ctx context.Context, ips []net.IP, port uint16, client io.ReadWriteCloser, dialer dialers.Dialer, idleTimeout time.Duration) (*TCPProxy, error) {
+	server, err := dialHappyEyeballs(ctx, dialer.DialContext, ips, port, ConnectionAttemptDelay)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TCPProxy{server: server, client: client}, nil
+	localIp, localPort := splitAddr(server.LocalAddr())
+	remoteIp, _ := splitAddr(server.RemoteAddr())
+	return &TCPProxy{server: server, client: client, idleTimeout: idleTimeout, LocalIp: localIp, LocalPort: localPort, RemoteIp: remoteIp}, nil
+}
+
+// Returns the IP and port of the address. Connections of custom dialers, i.e. chained through another proxy, may not
+// have a *net.TCPAddr, in which case the string form is parsed and the unspecified address is used if that fails.
+func splitAddr(addr net.Addr) (net.IP, uint16) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP, uint16(tcpAddr.Port)
+	}
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return net.IPv4zero, 0
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	return ip, uint16(port)
 }
 
 func (proxy *TCPProxy) Start(errors chan error) error {
-	SpliceConnections(proxy.server, proxy.client, errors)
+	SpliceConnections(proxy.client, proxy.server, utils.NewInactivityHandler(proxy.idleTimeout), errors)
 	return nil
 }
 
@@ -0,0 +1,145 @@
+package proxies
+
+import (
+	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReassemblyTimeout is the reassembly timer of RFC1928, which must not be less than 5 seconds.
+// When it expires the fragments of the incomplete datagram are dropped.
+const ReassemblyTimeout = time.Duration(5) * time.Second
+
+// The FRAG field of the UDP request header: the low 7 bits are the position of the fragment, starting at 1,
+// and the high bit marks the last fragment of the datagram
+const (
+	fragPositionMask = 0x7f
+	fragEndMarker    = 0x80
+)
+
+// FragmentPolicy decides what the UDP relay does with datagrams whose FRAG field isn't zero
+type FragmentPolicy uint8
+
+const (
+	// FragmentsDrop drops every fragment, which RFC1928 requires from relays not implementing fragmentation
+	FragmentsDrop FragmentPolicy = iota
+	// FragmentsReassemble reassembles the fragments 1..n of a datagram in order and relays the datagram once its last fragment is received
+	FragmentsReassemble
+)
+
+// FragmentStats counts the outcome of the fragments received by the UDP relays. A single FragmentStats can be shared by all associations.
+// Reassembled counts the reassembled datagrams, the other counters count the dropped fragments.
+type FragmentStats struct {
+	// Reassembled is the number of datagrams reassembled from their fragments
+	Reassembled atomic.Uint64
+	// Dropped is the number of fragments dropped by the FragmentsDrop policy or because the reassembled datagram would be too big
+	Dropped atomic.Uint64
+	// OutOfOrder is the number of fragments dropped because they didn't come in order, including the ones queued before them
+	OutOfOrder atomic.Uint64
+	// Expired is the number of queued fragments dropped because the reassembly timer expired
+	Expired atomic.Uint64
+}
+
+// The reassembly queue of an association. There is a single queue, as RFC1928 describes it, so the fragments
+// of different datagrams can't be interleaved. The destination of the datagram is taken from its first fragment.
+type reassemblyQueue struct {
+	policy  FragmentPolicy
+	stats   *FragmentStats
+	timeout time.Duration
+
+	mu        sync.Mutex // guards the fields below, which are also accessed by the reassembly timer
+	fragments [][]byte
+	size      int
+	dstAddr   shared.DstAddr
+	dstPort   uint16
+	timer     *time.Timer
+	datagrams uint64 // the number of datagrams started, so a timer of a previous datagram doesn't expire the current one
+}
+
+func newReassemblyQueue(policy FragmentPolicy, stats *FragmentStats, timeout time.Duration) *reassemblyQueue {
+	return &reassemblyQueue{policy: policy, stats: stats, timeout: timeout}
+}
+
+// Adds the datagram to the queue. Returns the datagram to relay and true, when the datagram isn't fragmented or it was the last
+// fragment of a datagram, otherwise false. The DATA of the fragments is kept, so it mustn't be reused by the caller.
+func (queue *reassemblyQueue) add(dgram udp.UDPDatagram) (udp.UDPDatagram, bool) {
+	if dgram.Frag == 0 {
+		return dgram, true
+	}
+	if queue.policy != FragmentsReassemble {
+		queue.stats.Dropped.Add(1)
+		return udp.UDPDatagram{}, false
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	position := int(dgram.Frag & fragPositionMask)
+	if position != len(queue.fragments)+1 || (position > 1 && !queue.isSameDestination(dgram)) {
+		// a fragment out of order, or one of a new datagram, abandons the queued ones
+		queue.stats.OutOfOrder.Add(uint64(len(queue.fragments)))
+		queue.reset()
+		if position != 1 {
+			queue.stats.OutOfOrder.Add(1)
+			return udp.UDPDatagram{}, false
+		}
+	}
+	if queue.size+len(dgram.DATA) > maxDatagramSize {
+		queue.stats.Dropped.Add(uint64(len(queue.fragments) + 1))
+		queue.reset()
+		return udp.UDPDatagram{}, false
+	}
+
+	if position == 1 {
+		queue.dstAddr, queue.dstPort = dgram.DST_ADDR, dgram.DST_PORT
+		queue.datagrams++
+		datagram := queue.datagrams
+		queue.timer = time.AfterFunc(queue.timeout, func() { queue.expire(datagram) })
+	}
+	queue.fragments = append(queue.fragments, dgram.DATA)
+	queue.size += len(dgram.DATA)
+	if dgram.Frag&fragEndMarker == 0 {
+		return udp.UDPDatagram{}, false
+	}
+
+	data := make([]byte, 0, queue.size)
+	for _, fragment := range queue.fragments {
+		data = append(data, fragment...)
+	}
+	complete := udp.UDPDatagram{DST_ADDR: queue.dstAddr, DST_PORT: queue.dstPort, DATA: data}
+	queue.reset()
+	queue.stats.Reassembled.Add(1)
+	return complete, true
+}
+
+// Drops the queued fragments once the timer expires, unless the datagram was completed or abandoned meanwhile
+func (queue *reassemblyQueue) expire(datagram uint64) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if len(queue.fragments) == 0 || queue.datagrams != datagram {
+		return
+	}
+	queue.stats.Expired.Add(uint64(len(queue.fragments)))
+	queue.reset()
+}
+
+// Stops the reassembly timer, the queued fragments are dropped
+func (queue *reassemblyQueue) close() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	queue.reset()
+}
+
+func (queue *reassemblyQueue) isSameDestination(dgram udp.UDPDatagram) bool {
+	return queue.dstAddr == dgram.DST_ADDR && queue.dstPort == dgram.DST_PORT
+}
+
+func (queue *reassemblyQueue) reset() {
+	if queue.timer != nil {
+		queue.timer.Stop()
+		queue.timer = nil
+	}
+	queue.fragments = nil
+	queue.size = 0
+}
@@ -0,0 +1,135 @@
+package proxies
+
+import (
+	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"testing"
+	"time"
+)
+
+func fragment(frag uint16, host string, data string) udp.UDPDatagram {
+	return udp.UDPDatagram{Frag: frag, DST_ADDR: shared.NewDstAddr(host), DST_PORT: 53, DATA: []byte(data)}
+}
+
+func Test_ReassemblyQueue_Must_Reassemble_In_Order_Fragments(t *testing.T) {
+	stats := &FragmentStats{}
+	queue := newReassemblyQueue(FragmentsReassemble, stats, ReassemblyTimeout)
+	defer queue.close()
+
+	if _, complete := queue.add(fragment(1, "10.0.0.1", "he")); complete {
+		t.Fatal("Expected the first fragment to be queued")
+	}
+	if _, complete := queue.add(fragment(2, "10.0.0.1", "ll")); complete {
+		t.Fatal("Expected the second fragment to be queued")
+	}
+	dgram, complete := queue.add(fragment(3|fragEndMarker, "10.0.0.1", "o"))
+	if !complete || string(dgram.DATA) != "hello" || dgram.Frag != 0 || dgram.DST_ADDR.Value != "10.0.0.1" || dgram.DST_PORT != 53 {
+		t.Fatalf("Expected the reassembled datagram, got %+v", dgram)
+	}
+	if stats.Reassembled.Load() != 1 {
+		t.Fatal("Expected a reassembled datagram, got", stats.Reassembled.Load())
+	}
+}
+
+func Test_ReassemblyQueue_Must_Pass_Whole_Datagrams(t *testing.T) {
+	for _, policy := range []FragmentPolicy{FragmentsDrop, FragmentsReassemble} {
+		queue := newReassemblyQueue(policy, &FragmentStats{}, ReassemblyTimeout)
+		if dgram, complete := queue.add(fragment(0, "10.0.0.1", "whole")); !complete || string(dgram.DATA) != "whole" {
+			t.Fatalf("Expected the whole datagram to pass with policy %d, got %+v", policy, dgram)
+		}
+		queue.close()
+	}
+}
+
+func Test_ReassemblyQueue_Must_Drop_Fragments_With_Drop_Policy(t *testing.T) {
+	stats := &FragmentStats{}
+	queue := newReassemblyQueue(FragmentsDrop, stats, ReassemblyTimeout)
+	defer queue.close()
+
+	queue.add(fragment(1, "10.0.0.1", "he"))
+	if _, complete := queue.add(fragment(2|fragEndMarker, "10.0.0.1", "llo")); complete {
+		t.Fatal("Expected the fragments to be dropped")
+	}
+	if stats.Dropped.Load() != 2 || stats.Reassembled.Load() != 0 {
+		t.Fatalf("Expected 2 dropped fragments, got %d dropped and %d reassembled", stats.Dropped.Load(), stats.Reassembled.Load())
+	}
+}
+
+func Test_ReassemblyQueue_Must_Drop_Out_Of_Order_Fragments(t *testing.T) {
+	tests := []struct {
+		name       string
+		fragments  []udp.UDPDatagram
+		outOfOrder uint64
+	}{
+		{"missing first fragment", []udp.UDPDatagram{fragment(2|fragEndMarker, "10.0.0.1", "b")}, 1},
+		{"gap", []udp.UDPDatagram{fragment(1, "10.0.0.1", "a"), fragment(3|fragEndMarker, "10.0.0.1", "c")}, 2},
+		{"repeated position", []udp.UDPDatagram{fragment(1, "10.0.0.1", "a"), fragment(2, "10.0.0.1", "b"), fragment(2|fragEndMarker, "10.0.0.1", "b")}, 3},
+		{"other destination", []udp.UDPDatagram{fragment(1, "10.0.0.1", "a"), fragment(2|fragEndMarker, "10.0.0.2", "b")}, 2},
+		{"position zero", []udp.UDPDatagram{fragment(fragEndMarker, "10.0.0.1", "a")}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := &FragmentStats{}
+			queue := newReassemblyQueue(FragmentsReassemble, stats, ReassemblyTimeout)
+			defer queue.close()
+			for _, dgram := range test.fragments {
+				if _, complete := queue.add(dgram); complete {
+					t.Fatal("Expected no datagram to be reassembled")
+				}
+			}
+			if stats.OutOfOrder.Load() != test.outOfOrder {
+				t.Fatalf("Expected %d out of order fragments, got %d", test.outOfOrder, stats.OutOfOrder.Load())
+			}
+		})
+	}
+}
+
+func Test_ReassemblyQueue_Must_Restart_With_A_New_First_Fragment(t *testing.T) {
+	stats := &FragmentStats{}
+	queue := newReassemblyQueue(FragmentsReassemble, stats, ReassemblyTimeout)
+	defer queue.close()
+
+	queue.add(fragment(1, "10.0.0.1", "lost"))
+	queue.add(fragment(1, "10.0.0.1", "he"))
+	dgram, complete := queue.add(fragment(2|fragEndMarker, "10.0.0.1", "llo"))
+	if !complete || string(dgram.DATA) != "hello" {
+		t.Fatalf("Expected the second datagram to be reassembled, got %q", dgram.DATA)
+	}
+	if stats.OutOfOrder.Load() != 1 {
+		t.Fatal("Expected the abandoned fragment to be counted, got", stats.OutOfOrder.Load())
+	}
+}
+
+func Test_ReassemblyQueue_Must_Expire_Incomplete_Datagrams(t *testing.T) {
+	stats := &FragmentStats{}
+	queue := newReassemblyQueue(FragmentsReassemble, stats, 50*time.Millisecond)
+	defer queue.close()
+
+	queue.add(fragment(1, "10.0.0.1", "he"))
+	queue.add(fragment(2, "10.0.0.1", "ll"))
+	deadline := time.Now().Add(5 * time.Second)
+	for stats.Expired.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats.Expired.Load() != 2 {
+		t.Fatal("Expected both fragments to expire, got", stats.Expired.Load())
+	}
+	if _, complete := queue.add(fragment(3|fragEndMarker, "10.0.0.1", "o")); complete {
+		t.Fatal("Expected the last fragment of the expired datagram to be dropped")
+	}
+}
+
+func Test_ReassemblyQueue_Must_Drop_Datagrams_Bigger_Than_UDP_Allows(t *testing.T) {
+	stats := &FragmentStats{}
+	queue := newReassemblyQueue(FragmentsReassemble, stats, ReassemblyTimeout)
+	defer queue.close()
+
+	big := string(make([]byte, maxDatagramSize/2+1))
+	queue.add(fragment(1, "10.0.0.1", big))
+	if _, complete := queue.add(fragment(2|fragEndMarker, "10.0.0.1", big)); complete {
+		t.Fatal("Expected the oversized datagram to be dropped")
+	}
+	if stats.Dropped.Load() != 2 {
+		t.Fatal("Expected both fragments to be dropped, got", stats.Dropped.Load())
+	}
+}
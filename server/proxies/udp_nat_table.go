@@ -0,0 +1,204 @@
+package proxies
+
+import (
+	"net"
+	"net/netip"
+	"socks5_server/messages/shared"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Maximum size of a UDP datagram
+const maxDatagramSize = 65535
+
+// Number of datagrams queued for a destination, i.e. while it is being resolved. Further datagrams are dropped, as UDP is lossy anyway.
+const destinationQueueSize = 64
+
+// Maximum number of destinations of an association, datagrams to new destinations are dropped once it is reached
+const maxNatEntries = 1024
+
+// A destination which exchanged no datagrams for this long is removed from the NAT table
+const natEntryTimeout = time.Duration(2) * time.Minute
+
+// Resolves a destination requested by the client to the address its datagrams are sent to
+type resolveDestinationFunc func(dstAddr shared.DstAddr, dstPort uint16) (netip.AddrPort, error)
+
+// Delivers a datagram received from a remote to the client
+type replyFunc func(dstAddr shared.DstAddr, dstPort uint16, data []byte)
+
+// The NAT table of a UDP association. All datagrams of the association are sent and received on a single unconnected outbound socket,
+// so the datagrams of any remote are relayed back to the client, whether the client sent anything to it or not.
+// The table only keeps the destinations requested by the client: each one resolves its address and writes the datagrams for it
+// in its own goroutine, so a slow destination can't stall the relay. Destinations are keyed as requested by the client, so the
+// datagrams coming back from them carry the same address, even if it was a hostname. The datagrams of any other remote carry its IP.
+type natTable struct {
+	outbound net.PacketConn
+	resolve  resolveDestinationFunc
+	reply    replyFunc
+
+	mu        sync.Mutex
+	entries   map[string]*natEntry
+	resolved  map[netip.AddrPort]*natEntry // the entries by the address they resolved to, to recognize the datagrams coming back
+	closed    bool
+	closeOnce sync.Once
+}
+
+type natEntry struct {
+	dstAddr    shared.DstAddr
+	dstPort    uint16
+	addr       netip.AddrPort // set once the destination is resolved
+	queue      chan []byte
+	lastActive atomic.Int64
+	done       chan struct{}
+	doneOnce   sync.Once
+}
+
+func newNatTable(outbound net.PacketConn, resolve resolveDestinationFunc, reply replyFunc) *natTable {
+	return &natTable{outbound: outbound, resolve: resolve, reply: reply, entries: make(map[string]*natEntry), resolved: make(map[netip.AddrPort]*natEntry)}
+}
+
+// Queues the datagram for the destination, creating its entry if needed. It never blocks, so a slow destination can't stall the relay.
+func (table *natTable) send(dstAddr shared.DstAddr, dstPort uint16, data []byte) {
+	key := net.JoinHostPort(dstAddr.Value, strconv.Itoa(int(dstPort)))
+	table.mu.Lock()
+	if table.closed {
+		table.mu.Unlock()
+		return
+	}
+	entry, ok := table.entries[key]
+	if !ok {
+		if len(table.entries) >= maxNatEntries {
+			table.mu.Unlock()
+			return
+		}
+		entry = &natEntry{dstAddr: dstAddr, dstPort: dstPort, queue: make(chan []byte, destinationQueueSize), done: make(chan struct{})}
+		table.entries[key] = entry
+		go table.run(key, entry)
+	}
+	table.mu.Unlock()
+
+	entry.touch()
+	select {
+	case entry.queue <- data:
+	default:
+	}
+}
+
+// Relays the datagrams received on the outbound socket to the client, until the socket is closed
+func (table *natTable) receive() error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, from, err := table.outbound.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		udpAddr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		source := udpAddr.AddrPort()
+		source = netip.AddrPortFrom(source.Addr().Unmap(), source.Port())
+		if entry := table.lookup(source); entry != nil {
+			entry.touch()
+			table.reply(entry.dstAddr, entry.dstPort, buf[:n])
+			continue
+		}
+		table.reply(shared.NewDstAddrFromIP(source.Addr().AsSlice()), source.Port(), buf[:n])
+	}
+}
+
+// Removes all entries and closes the outbound socket
+func (table *natTable) close() {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.closed = true
+	for key, entry := range table.entries {
+		entry.finish()
+		delete(table.entries, key)
+	}
+	table.closeOnce.Do(func() {
+		table.outbound.Close()
+	})
+}
+
+func (table *natTable) size() int {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return len(table.entries)
+}
+
+// Resolves the destination of the entry and writes the queued datagrams to it until the entry is finished or idle for natEntryTimeout
+func (table *natTable) run(key string, entry *natEntry) {
+	defer table.remove(key, entry)
+	addr, err := table.resolve(entry.dstAddr, entry.dstPort)
+	if err != nil {
+		return
+	}
+	table.setResolved(entry, addr)
+	to := net.UDPAddrFromAddrPort(addr)
+
+	idleCheck := time.NewTimer(natEntryTimeout)
+	defer idleCheck.Stop()
+	for {
+		select {
+		case data := <-entry.queue:
+			// write errors, i.e. an unreachable network, don't end the entry
+			_, _ = table.outbound.WriteTo(data, to)
+		case <-idleCheck.C:
+			if entry.idle() {
+				return
+			}
+			idleCheck.Reset(natEntryTimeout - entry.idleFor())
+		case <-entry.done:
+			return
+		}
+	}
+}
+
+// Makes the datagrams coming from addr be relayed as coming from the destination of the entry. When several destinations resolve
+// to the same address, i.e. a hostname and its IP, the last one resolved is used.
+func (table *natTable) setResolved(entry *natEntry, addr netip.AddrPort) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	entry.addr = addr
+	table.resolved[addr] = entry
+}
+
+func (table *natTable) lookup(source netip.AddrPort) *natEntry {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.resolved[source]
+}
+
+// Removes the entry, unless it was already replaced by a new one for the same destination
+func (table *natTable) remove(key string, entry *natEntry) {
+	entry.finish()
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if table.entries[key] == entry {
+		delete(table.entries, key)
+	}
+	if table.resolved[entry.addr] == entry {
+		delete(table.resolved, entry.addr)
+	}
+}
+
+func (entry *natEntry) touch() {
+	entry.lastActive.Store(time.Now().UnixNano())
+}
+
+func (entry *natEntry) idleFor() time.Duration {
+	return time.Since(time.Unix(0, entry.lastActive.Load()))
+}
+
+func (entry *natEntry) idle() bool {
+	return entry.idleFor() >= natEntryTimeout
+}
+
+func (entry *natEntry) finish() {
+	entry.doneOnce.Do(func() {
+		close(entry.done)
+	})
+}
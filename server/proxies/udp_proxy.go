@@ -1,122 +1,202 @@
 package proxies
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net"
+	"net/netip"
+	"os"
 	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"socks5_server/server/dialers"
+	"socks5_server/server/resolvers"
+	"socks5_server/server/utils"
+	"sync"
+	"time"
 )
 
+// Timeout for resolving the FQDN destinations of the datagrams
+const resolveTimeout = time.Duration(5) * time.Second
+
+// A relay for the datagrams of a UDP association. The client sends encapsulated datagrams to the relay, which forwards them
+// to their destinations through the NAT table of the association and encapsulates the datagrams of any remote coming back to it.
+// Port and Addr are the address of the relay, which is returned to the client as BND.PORT.
+// As RFC1928 requires, the association ends with the TCP connection of the client (control) and only datagrams from the client's address are relayed.
 type UDPProxy struct {
-	server *net.UDPConn
-	Port   uint16
-	Addr   string
+	server   *net.UDPConn
+	control  io.ReadWriteCloser
+	expected *net.UDPAddr
+	resolver resolvers.Resolver
+	nat      *natTable
+	queue    *reassemblyQueue
+	idle     *utils.InactivityHandler
+	Port     uint16
+	Addr     string
+
+	clientMu   sync.Mutex // guards clientAddr, which is written by the relay loop and read by the NAT table
+	clientAddr *net.UDPAddr
 }
 
-func NewUDPProxy() (*UDPProxy, error) {
+// NewUDPProxy creates the relay of the association controlled by the TCP connection control. Only datagrams from the IP of
+// expectedClient are relayed, and only from its port when it isn't zero. The datagrams are sent to the remotes from a single socket
+// opened with listener. Fragmented datagrams are handled according to fragments and their outcome is counted in stats.
+// The association ends once no datagram is relayed in either direction for idleTimeout, zero means no idle timeout.
+func NewUDPProxy(control io.ReadWriteCloser, expectedClient *net.UDPAddr, resolver resolvers.Resolver, listener dialers.PacketListener, fragments FragmentPolicy, stats *FragmentStats, idleTimeout time.Duration) (*UDPProxy, error) {
 	udpServer, err := startUdpListener()
 	if err != nil {
 		return nil, err
 	}
+	// an ephemeral port on all interfaces, IPv4 and IPv6, so any destination can be reached
+	outbound, err := listener.ListenPacket(context.Background(), "udp", ":0")
+	if err != nil {
+		udpServer.Close()
+		return nil, err
+	}
 
 	port := uint16(udpServer.LocalAddr().(*net.UDPAddr).Port)
 	addr := udpServer.LocalAddr().String()
 
-	return &UDPProxy{server: udpServer, Port: port, Addr: addr}, nil
+	proxy := &UDPProxy{server: udpServer, control: control, expected: expectedClient, resolver: resolver, Port: port, Addr: addr}
+	proxy.nat = newNatTable(outbound, proxy.resolveDestination, proxy.replyToClient)
+	proxy.queue = newReassemblyQueue(fragments, stats, ReassemblyTimeout)
+	proxy.idle = utils.NewInactivityHandler(idleTimeout)
+	return proxy, nil
 }
 
 func (proxy *UDPProxy) Start(errors chan error) error {
 	go func() {
-		for {
-			addrClient, dgram, err := proxy.receiveRequest()
-			if err != nil {
-				errors <- err
-				return
-
-			}
-			responseData, err := sendToRemote(dgram.DATA, concatIpAndPort(dgram.DST_ADDR.Value, dgram.DST_PORT))
-			if err != nil {
-				errors <- err
-				return
-			}
-
-			respDgram := encapsulateResponse(dgram, responseData)
-			resp, err := respDgram.ToBytes()
-			if err != nil {
-				errors <- err
-				return
-			}
-
-			_, err = proxy.server.WriteToUDP(resp, addrClient)
-			if err != nil {
-				errors <- err
-				return
-			}
-		}
+		errors <- proxy.relayFromClient()
 	}()
+	go func() {
+		errors <- proxy.watchControl()
+	}()
+	// the outbound socket only fails once it is closed by Stop, which is already reported by the relay loop
+	go proxy.nat.receive()
 	return nil
 }
 
 func (proxy *UDPProxy) Stop() {
 	proxy.server.Close()
+	proxy.control.Close()
+	proxy.nat.close()
+	proxy.queue.close()
 }
 
-func sendToRemote(data []byte, addr string) ([]byte, error) {
-	udpAddrSrv, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return nil, err
-	}
-	conn, err := net.DialUDP("udp", nil, udpAddrSrv)
-	if err != nil {
-		return nil, err
+// The client sends nothing over the TCP connection during the association, so the read returns only once it is closed.
+// Reports nil when the client closed it, which ends the association.
+func (proxy *UDPProxy) watchControl() error {
+	_, err := io.Copy(io.Discard, proxy.control)
+	return err
+}
+
+// Reports whether the datagram comes from the client of the association
+func (proxy *UDPProxy) isFromClient(addr *net.UDPAddr) bool {
+	if !proxy.expected.IP.Equal(addr.IP) {
+		return false
 	}
+	return proxy.expected.Port == 0 || proxy.expected.Port == addr.Port
+}
 
-	n, err := conn.Write(data)
-	if err != nil {
-		return nil, err
+// Reads the datagrams of the client and hands them to the NAT table. Datagrams from other sources and malformed datagrams are dropped,
+// fragmented ones go through the reassembly queue. Returns when the relay socket fails, i.e. on Stop, or when the association is idle.
+func (proxy *UDPProxy) relayFromClient() error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		if err := proxy.server.SetReadDeadline(proxy.idle.NextCheck()); err != nil {
+			return err
+		}
+		n, clientAddr, err := proxy.server.ReadFromUDP(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if proxy.idle.IsIdle() {
+				return proxy.idle.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !proxy.isFromClient(clientAddr) {
+			continue
+		}
+		dgram := udp.UDPDatagram{}
+		if err := dgram.Deserialize(buf[:n]); err != nil {
+			continue
+		}
+		proxy.setClientAddr(clientAddr)
+		proxy.idle.RecordActivityNow(utils.Upstream)
+		// DATA points into buf, which is reused by the next read
+		dgram.DATA = bytes.Clone(dgram.DATA)
+		dgram, complete := proxy.queue.add(dgram)
+		if !complete {
+			continue
+		}
+		proxy.nat.send(dgram.DST_ADDR, dgram.DST_PORT, dgram.DATA)
 	}
+}
 
-	buf := make([]byte, 1024)
-	n, err = conn.Read(buf)
+// Resolves the destination with the resolver of the proxy, if it is a hostname.
+// A hostname resolving to no address is an error, so the datagrams to it are dropped.
+func (proxy *UDPProxy) resolveDestination(dstAddr shared.DstAddr, dstPort uint16) (netip.AddrPort, error) {
+	if dstAddr.Type != shared.ATYP_FQDN {
+		addr, err := netip.ParseAddr(dstAddr.Value)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		return netip.AddrPortFrom(addr.Unmap(), dstPort), nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	ips, err := proxy.resolver.LookupIP(ctx, dstAddr.Value)
+	cancel()
 	if err != nil {
-		return nil, err
+		return netip.AddrPort{}, err
 	}
-
-	return buf[:n], nil
+	if len(ips) == 0 {
+		return netip.AddrPort{}, &net.DNSError{Err: "no addresses", Name: dstAddr.Value, IsNotFound: true}
+	}
+	addr, ok := netip.AddrFromSlice(ips[0])
+	if !ok {
+		return netip.AddrPort{}, &net.AddrError{Err: "invalid IP", Addr: ips[0].String()}
+	}
+	return netip.AddrPortFrom(addr.Unmap(), dstPort), nil
 }
 
-func (proxy *UDPProxy) receiveRequest() (*net.UDPAddr, *udp.UDPDatagram, error) {
-	var buf = make([]byte, 65535) // max udp?
-	n, addrClient, err := proxy.server.ReadFromUDP(buf)
-	if err != nil {
-		return nil, nil, err
+// Encapsulates a datagram received from the destination and sends it to the client
+func (proxy *UDPProxy) replyToClient(dstAddr shared.DstAddr, dstPort uint16, data []byte) {
+	clientAddr := proxy.getClientAddr()
+	if clientAddr == nil {
+		return
 	}
-	dgram := udp.UDPDatagram{}
-	err = dgram.Deserialize(buf[:n])
+	dgram := udp.UDPDatagram{DST_ADDR: dstAddr, DST_PORT: dstPort, DATA: data}
+	resp, err := dgram.ToBytes()
 	if err != nil {
-		return nil, nil, err
+		return
+	}
+	if _, err := proxy.server.WriteToUDP(resp, clientAddr); err == nil {
+		proxy.idle.RecordActivityNow(utils.Downstream)
 	}
-	return addrClient, &dgram, nil
 }
 
-func encapsulateResponse(requestDatagram *udp.UDPDatagram, data []byte) *udp.UDPDatagram {
-	respDgram := udp.UDPDatagram{}
-	respDgram.DST_ADDR = requestDatagram.DST_ADDR
-	respDgram.DST_PORT = requestDatagram.DST_PORT
-	respDgram.Frag = requestDatagram.Frag
-	respDgram.DATA = data
-	return &respDgram
+func (proxy *UDPProxy) setClientAddr(addr *net.UDPAddr) {
+	proxy.clientMu.Lock()
+	defer proxy.clientMu.Unlock()
+	proxy.clientAddr = addr
 }
 
-func concatIpAndPort(addr string, port uint16) string {
-	return fmt.Sprintf("%s:%d", addr, port)
+func (proxy *UDPProxy) getClientAddr() *net.UDPAddr {
+	proxy.clientMu.Lock()
+	defer proxy.clientMu.Unlock()
+	return proxy.clientAddr
 }
 
+// Listens on all interfaces, IPv4 and IPv6, so the client can reach the relay via the same address family it used for the TCP connection
 func startUdpListener() (*net.UDPConn, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", "0.0.0.0:0")
+	udpAddr, err := net.ResolveUDPAddr("udp", ":0")
 	if err != nil {
 		return nil, err
 	}
-	udpServer, err := net.ListenUDP("udp4", udpAddr)
+	udpServer, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
 		return nil, err
 	}
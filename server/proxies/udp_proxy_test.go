@@ -0,0 +1,321 @@
+package proxies
+
+import (
+	"context"
+	"errors"
+	"net"
+	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"socks5_server/server/dialers"
+	"socks5_server/server/resolvers"
+	"socks5_server/server/utils"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Blocks the lookups of slow.example until release is closed, any other name resolves to the loopback
+type slowResolver struct {
+	release chan struct{}
+}
+
+func (resolver slowResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if host == "slow.example" {
+		<-resolver.release
+	}
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+// Resolves empty.example to no address, like a resolver answering with an empty record set, any other name to the loopback
+type emptyResolver struct{}
+
+func (resolver emptyResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if host == "empty.example" {
+		return []net.IP{}, nil
+	}
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+// Starts the relay for a client on the loopback and returns the client's connection to it
+func startUdpProxy(t *testing.T, resolver resolvers.Resolver) (*UDPProxy, *net.UDPConn) {
+	proxy, conn, _, _ := startUdpProxyFor(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, resolver, FragmentsDrop)
+	return proxy, conn
+}
+
+// Starts the relay for the expected client and returns the client's connection to it, the client side of the control
+// connection and the channel on which the relay reports
+func startUdpProxyFor(t *testing.T, expected *net.UDPAddr, resolver resolvers.Resolver, fragments FragmentPolicy) (*UDPProxy, *net.UDPConn, net.Conn, chan error) {
+	control, controlClient := net.Pipe()
+	t.Cleanup(func() { controlClient.Close() })
+	proxy, err := NewUDPProxy(control, expected, resolver, dialers.NetDialer{}, fragments, &FragmentStats{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(proxy.Stop)
+	errors := make(chan error, 2)
+	proxy.Start(errors)
+	conn := dialRelay(t, proxy)
+	return proxy, conn, controlClient, errors
+}
+
+func dialRelay(t *testing.T, proxy *UDPProxy) *net.UDPConn {
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: int(proxy.Port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// Starts a UDP server, which answers every datagram with the given number of replies and reports the source of each datagram
+func startRepeatingServer(t *testing.T, replies int) (uint16, chan string) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	sources := make(chan string, 16)
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			sources <- addr.String()
+			for i := 0; i < replies; i++ {
+				_, _ = conn.WriteToUDP(append(buf[:n:n], byte('0'+i)), addr)
+			}
+		}
+	}()
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port), sources
+}
+
+func sendDatagram(t *testing.T, conn *net.UDPConn, host string, port uint16, data string) {
+	dgram := udp.UDPDatagram{DST_ADDR: shared.NewDstAddr(host), DST_PORT: port, DATA: []byte(data)}
+	bytes, err := dgram.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(bytes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receiveDatagram(t *testing.T, conn *net.UDPConn) udp.UDPDatagram {
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgram := udp.UDPDatagram{}
+	if err := dgram.Deserialize(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	return dgram
+}
+
+func Test_UDPProxy_Must_Relay_Unsolicited_Replies_Over_The_Same_Socket(t *testing.T) {
+	_, conn := startUdpProxy(t, resolvers.NetResolver{})
+	port, sources := startRepeatingServer(t, 3)
+
+	sendDatagram(t, conn, "127.0.0.1", port, "ping")
+	for i := 0; i < 3; i++ {
+		dgram := receiveDatagram(t, conn)
+		if expected := "ping" + strconv.Itoa(i); string(dgram.DATA) != expected {
+			t.Fatalf("Expected %q, got %q", expected, dgram.DATA)
+		}
+		if dgram.DST_ADDR.Value != "127.0.0.1" || dgram.DST_PORT != port {
+			t.Fatalf("Expected the reply to come from 127.0.0.1:%v, got %v:%v", port, dgram.DST_ADDR.Value, dgram.DST_PORT)
+		}
+	}
+
+	sendDatagram(t, conn, "127.0.0.1", port, "again")
+	if first, second := <-sources, <-sources; first != second {
+		t.Fatalf("Expected the datagrams to the same destination to use the same socket, got %v and %v", first, second)
+	}
+}
+
+func Test_UDPProxy_Must_Relay_Datagrams_Of_Any_Remote(t *testing.T) {
+	_, conn := startUdpProxy(t, resolvers.NetResolver{})
+	port, sources := startRepeatingServer(t, 1)
+
+	sendDatagram(t, conn, "127.0.0.1", port, "ping")
+	receiveDatagram(t, conn)
+	// a remote the client never sent anything to learns the outbound address of the relay, i.e. from the first destination
+	outbound, err := net.ResolveUDPAddr("udp", <-sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if _, err := remote.WriteToUDP([]byte("unsolicited"), outbound); err != nil {
+		t.Fatal(err)
+	}
+	remotePort := uint16(remote.LocalAddr().(*net.UDPAddr).Port)
+	if dgram := receiveDatagram(t, conn); string(dgram.DATA) != "unsolicited" || dgram.DST_ADDR.Value != "127.0.0.1" || dgram.DST_PORT != remotePort {
+		t.Fatalf("Expected the datagram of 127.0.0.1:%v, got %q from %v:%v", remotePort, dgram.DATA, dgram.DST_ADDR.Value, dgram.DST_PORT)
+	}
+}
+
+func Test_UDPProxy_Must_Not_Block_On_A_Slow_Destination(t *testing.T) {
+	resolver := slowResolver{release: make(chan struct{})}
+	_, conn := startUdpProxy(t, resolver)
+	port, _ := startRepeatingServer(t, 1)
+
+	sendDatagram(t, conn, "slow.example", port, "slow")
+	// malformed datagrams are dropped without stopping the relay
+	if _, err := conn.Write([]byte{0x00, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	sendDatagram(t, conn, "fast.example", port, "fast")
+	if dgram := receiveDatagram(t, conn); string(dgram.DATA) != "fast0" || dgram.DST_ADDR.Value != "fast.example" {
+		t.Fatalf("Expected the reply of fast.example while slow.example is resolved, got %q from %v", dgram.DATA, dgram.DST_ADDR.Value)
+	}
+
+	close(resolver.release)
+	if dgram := receiveDatagram(t, conn); string(dgram.DATA) != "slow0" || dgram.DST_ADDR.Value != "slow.example" {
+		t.Fatalf("Expected the queued datagram to reach slow.example, got %q from %v", dgram.DATA, dgram.DST_ADDR.Value)
+	}
+}
+
+func Test_UDPProxy_Must_Drop_Datagrams_To_Hosts_Without_Addresses(t *testing.T) {
+	proxy, conn := startUdpProxy(t, emptyResolver{})
+	port, _ := startRepeatingServer(t, 1)
+
+	var dnsErr *net.DNSError
+	if _, err := proxy.resolveDestination(shared.NewDstAddr("empty.example"), port); !errors.As(err, &dnsErr) {
+		t.Fatal("Expected a DNS error for a host without addresses, got", err)
+	}
+	sendDatagram(t, conn, "empty.example", port, "dropped")
+	sendDatagram(t, conn, "other.example", port, "relayed")
+	if dgram := receiveDatagram(t, conn); string(dgram.DATA) != "relayed0" || dgram.DST_ADDR.Value != "other.example" {
+		t.Fatalf("Expected the relay to go on after the dropped datagram, got %q from %v", dgram.DATA, dgram.DST_ADDR.Value)
+	}
+}
+
+func Test_UDPProxy_Must_Drop_Fragmented_Datagrams(t *testing.T) {
+	proxy, conn := startUdpProxy(t, resolvers.NetResolver{})
+	port, _ := startRepeatingServer(t, 1)
+
+	dgram := udp.UDPDatagram{Frag: 1, DST_ADDR: shared.NewDstAddr("127.0.0.1"), DST_PORT: port, DATA: []byte("fragment")}
+	bytes, _ := dgram.ToBytes()
+	if _, err := conn.Write(bytes); err != nil {
+		t.Fatal(err)
+	}
+	sendDatagram(t, conn, "127.0.0.1", port, "whole")
+	if reply := receiveDatagram(t, conn); string(reply.DATA) != "whole0" {
+		t.Fatalf("Expected only the reply to the whole datagram, got %q", reply.DATA)
+	}
+	if size := proxy.nat.size(); size != 1 {
+		t.Fatal("Expected a single NAT entry, got", size)
+	}
+	if dropped := proxy.queue.stats.Dropped.Load(); dropped != 1 {
+		t.Fatal("Expected the fragment to be counted as dropped, got", dropped)
+	}
+}
+
+func Test_UDPProxy_Must_Relay_Reassembled_Datagrams(t *testing.T) {
+	proxy, conn, _, _ := startUdpProxyFor(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, resolvers.NetResolver{}, FragmentsReassemble)
+	port, _ := startRepeatingServer(t, 1)
+
+	for _, fragment := range []udp.UDPDatagram{
+		{Frag: 1, DATA: []byte("frag")},
+		{Frag: 2, DATA: []byte("men")},
+		{Frag: 3 | fragEndMarker, DATA: []byte("ted")},
+	} {
+		fragment.DST_ADDR, fragment.DST_PORT = shared.NewDstAddr("127.0.0.1"), port
+		bytes, _ := fragment.ToBytes()
+		if _, err := conn.Write(bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply := receiveDatagram(t, conn); string(reply.DATA) != "fragmented0" {
+		t.Fatalf("Expected the reply to the reassembled datagram, got %q", reply.DATA)
+	}
+	if reassembled := proxy.queue.stats.Reassembled.Load(); reassembled != 1 {
+		t.Fatal("Expected a reassembled datagram, got", reassembled)
+	}
+}
+
+func Test_UDPProxy_Must_Only_Relay_Datagrams_From_The_Client(t *testing.T) {
+	// the client announced its port, so a second socket on the same IP must be ignored
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	expected := client.LocalAddr().(*net.UDPAddr)
+	proxy, intruder, _, _ := startUdpProxyFor(t, expected, resolvers.NetResolver{}, FragmentsDrop)
+	port, sources := startRepeatingServer(t, 1)
+
+	sendDatagram(t, intruder, "127.0.0.1", port, "intruder")
+	dgram := udp.UDPDatagram{DST_ADDR: shared.NewDstAddr("127.0.0.1"), DST_PORT: port, DATA: []byte("client")}
+	bytes, _ := dgram.ToBytes()
+	if _, err := client.WriteToUDP(bytes, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: int(proxy.Port)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxDatagramSize)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dgram.Deserialize(buf[:n]); err != nil || string(dgram.DATA) != "client0" {
+		t.Fatalf("Expected the reply to the client, got %q, err %v", dgram.DATA, err)
+	}
+	if len(sources) != 1 {
+		t.Fatal("Expected only the datagram of the client to be relayed, got", len(sources)+1)
+	}
+}
+
+func Test_UDPProxy_Must_End_With_The_Control_Connection(t *testing.T) {
+	_, _, controlClient, errors := startUdpProxyFor(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, resolvers.NetResolver{}, FragmentsDrop)
+	controlClient.Close()
+	select {
+	case err := <-errors:
+		if err != nil {
+			t.Fatal("Expected the relay to report completion, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the relay to end once the control connection is closed")
+	}
+}
+
+func Test_UDPProxy_Must_End_Idle_Associations(t *testing.T) {
+	control, controlClient := net.Pipe()
+	defer controlClient.Close()
+	proxy, err := NewUDPProxy(control, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, resolvers.NetResolver{}, dialers.NetDialer{}, FragmentsDrop, &FragmentStats{}, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Stop()
+	reports := make(chan error, 2)
+	proxy.Start(reports)
+	conn := dialRelay(t, proxy)
+	port, _ := startRepeatingServer(t, 1)
+
+	// the association is active while datagrams are relayed, for twice the idle timeout
+	for i := 0; i < 10; i++ {
+		sendDatagram(t, conn, "127.0.0.1", port, "ping")
+		receiveDatagram(t, conn)
+		time.Sleep(40 * time.Millisecond)
+	}
+	select {
+	case err := <-reports:
+		t.Fatal("Expected the association to stay open while active, got", err)
+	default:
+	}
+	select {
+	case err := <-reports:
+		if !errors.Is(err, utils.ErrIdleTimeout) {
+			t.Fatal("Expected the idle timeout, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the idle association to end")
+	}
+}
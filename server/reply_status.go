@@ -0,0 +1,31 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"socks5_server/messages/responses/command_response"
+	"syscall"
+)
+
+// Maps the error of reaching the remote side to the reply status defined in RFC1928, so the client can distinguish
+// i.e. a refused port from a failure of the proxy itself. Unknown errors are reported as SocksServerFailure.
+func replyStatusFromError(err error) uint16 {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return command_response.TtlExpired
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return command_response.ConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return command_response.NetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.EHOSTDOWN):
+		return command_response.HostUnreachable
+	}
+	// the name of the remote server cannot be resolved
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return command_response.HostUnreachable
+	}
+	return command_response.SocksServerFailure
+}
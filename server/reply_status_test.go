@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"socks5_server/messages/responses/command_response"
+	"syscall"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_ReplyStatusFromError(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+	errs := []error{
+		dialErr(syscall.ECONNREFUSED),
+		dialErr(syscall.ENETUNREACH),
+		dialErr(syscall.EHOSTUNREACH),
+		&net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}},
+		&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "invalid.", IsNotFound: true}},
+		errors.New("unknown"),
+	}
+	expected := []uint16{
+		command_response.ConnectionRefused,
+		command_response.NetworkUnreachable,
+		command_response.HostUnreachable,
+		command_response.TtlExpired,
+		command_response.HostUnreachable,
+		command_response.SocksServerFailure,
+	}
+	for i, err := range errs {
+		if status := replyStatusFromError(err); status != expected[i] {
+			t.Errorf("Expected status %v for %v, got %v", expected[i], err, status)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package resolvers
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// CachingResolver keeps the results of another Resolver for TTL. Concurrent lookups of the same name share a single
+// call to the underlying Resolver, which prevents resolver storms when many clients request the same name. Errors are not cached.
+type CachingResolver struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	nextPrune time.Time
+}
+
+type cacheEntry struct {
+	ready   chan struct{} // closed once the lookup has finished, ips and err must not be read before that
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+func NewCachingResolver(resolver Resolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{resolver: resolver, ttl: ttl, now: time.Now, entries: make(map[string]*cacheEntry)}
+}
+
+func (resolver *CachingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	resolver.mu.Lock()
+	entry, ok := resolver.entries[host]
+	if ok && entryIsFinished(entry) && (entry.err != nil || resolver.now().After(entry.expires)) {
+		ok = false
+	}
+	if !ok {
+		resolver.pruneExpiredLocked()
+		entry = &cacheEntry{ready: make(chan struct{})}
+		resolver.entries[host] = entry
+		go resolver.lookup(host, entry)
+	}
+	resolver.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.ips, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Executes the lookup independently of the context of the caller, so one cancelled caller doesn't fail the others waiting for the same name
+func (resolver *CachingResolver) lookup(host string, entry *cacheEntry) {
+	ips, err := resolver.resolver.LookupIP(context.Background(), host)
+	resolver.mu.Lock()
+	entry.ips = ips
+	entry.err = err
+	entry.expires = resolver.now().Add(resolver.ttl)
+	if err != nil && resolver.entries[host] == entry {
+		delete(resolver.entries, host)
+	}
+	resolver.mu.Unlock()
+	close(entry.ready)
+}
+
+// Removes the expired entries, at most once per TTL, so names which are not requested again don't stay in memory forever
+func (resolver *CachingResolver) pruneExpiredLocked() {
+	now := resolver.now()
+	if now.Before(resolver.nextPrune) {
+		return
+	}
+	resolver.nextPrune = now.Add(resolver.ttl)
+	for host, entry := range resolver.entries {
+		if entryIsFinished(entry) && now.After(entry.expires) {
+			delete(resolver.entries, host)
+		}
+	}
+}
+
+func entryIsFinished(entry *cacheEntry) bool {
+	select {
+	case <-entry.ready:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,32 @@
+package resolvers
+
+// Resolvers used by the server for the FQDN addresses requested by the clients (CONNECT and BIND targets, UDP datagram destinations).
+import (
+	"context"
+	"net"
+)
+
+// Resolver resolves a host name to its IP addresses. Implementations must be safe for concurrent use.
+// When the name doesn't exist, *net.DNSError is expected, so the server can reply with HostUnreachable.
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// NetResolver is the default Resolver, it uses net.Resolver and as such the system configuration
+type NetResolver struct {
+	// Resolver defaults to net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+func (resolver NetResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	netResolver := resolver.Resolver
+	if netResolver == nil {
+		netResolver = net.DefaultResolver
+	}
+	return netResolver.LookupIP(ctx, "ip", host)
+}
+
+// Returns the error, which the standard library returns when a host doesn't exist
+func notFoundError(host string) error {
+	return &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
@@ -0,0 +1,108 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts the lookups and blocks them until release is closed
+type countingResolver struct {
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (resolver *countingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	resolver.calls.Add(1)
+	if resolver.release != nil {
+		<-resolver.release
+	}
+	if resolver.err != nil {
+		return nil, resolver.err
+	}
+	return []net.IP{net.ParseIP("10.0.0.1")}, nil
+}
+
+func Test_StaticResolver(t *testing.T) {
+	resolver := StaticResolver{Hosts: map[string][]net.IP{"internal.example": {net.ParseIP("10.0.0.2")}}}
+	ips, err := resolver.LookupIP(context.Background(), "internal.example")
+	if err != nil || !ips[0].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("Expected pinned address, got %v, err %v", ips, err)
+	}
+
+	_, err = resolver.LookupIP(context.Background(), "missing.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("Expected not found DNS error, got %v", err)
+	}
+
+	resolver.Fallback = &countingResolver{}
+	ips, err = resolver.LookupIP(context.Background(), "missing.example")
+	if err != nil || !ips[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("Expected address from the fallback, got %v, err %v", ips, err)
+	}
+}
+
+func Test_CachingResolver_Caches_Until_TTL(t *testing.T) {
+	underlying := &countingResolver{}
+	resolver := NewCachingResolver(underlying, time.Minute)
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if underlying.calls.Load() != 1 {
+		t.Fatalf("Expected a single lookup, got %v", underlying.calls.Load())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if underlying.calls.Load() != 2 {
+		t.Fatalf("Expected the expired entry to be resolved again, got %v lookups", underlying.calls.Load())
+	}
+}
+
+func Test_CachingResolver_Coalesces_Concurrent_Lookups(t *testing.T) {
+	underlying := &countingResolver{release: make(chan struct{})}
+	resolver := NewCachingResolver(underlying, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(underlying.release)
+	wg.Wait()
+	if underlying.calls.Load() != 1 {
+		t.Fatalf("Expected concurrent lookups to share one call, got %v", underlying.calls.Load())
+	}
+}
+
+func Test_CachingResolver_Does_Not_Cache_Errors(t *testing.T) {
+	underlying := &countingResolver{err: errors.New("temporary failure")}
+	resolver := NewCachingResolver(underlying, time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := resolver.LookupIP(context.Background(), "example.com"); err == nil {
+			t.Fatal("Expected error")
+		}
+	}
+	if underlying.calls.Load() != 2 {
+		t.Fatalf("Expected failed lookups to be retried, got %v", underlying.calls.Load())
+	}
+}
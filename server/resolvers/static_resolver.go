@@ -0,0 +1,23 @@
+package resolvers
+
+import (
+	"context"
+	"net"
+)
+
+// StaticResolver resolves names from a fixed hosts map, which is useful for pinning internal names or in tests.
+// Names missing from Hosts are resolved by Fallback, or reported as not found when there is no Fallback.
+type StaticResolver struct {
+	Hosts    map[string][]net.IP
+	Fallback Resolver
+}
+
+func (resolver StaticResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ips, ok := resolver.Hosts[host]; ok && len(ips) > 0 {
+		return ips, nil
+	}
+	if resolver.Fallback != nil {
+		return resolver.Fallback.LookupIP(ctx, host)
+	}
+	return nil, notFoundError(host)
+}
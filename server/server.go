@@ -1,54 +1,319 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
+	"socks5_server/server/proxies"
+	"sync"
 	"time"
 )
 
 type SessionState uint16
 
 const PendingAuthMethods SessionState = 10
+const PendingAuthentication SessionState = 15
 const Authenticated SessionState = 20
 const Proxying SessionState = 30
+const Closed SessionState = 40
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Shutdown has been called
+var ErrServerClosed = errors.New("socks5: server closed")
+
+// Server accepts socks5 clients and serves their commands as configured in Config. It is modelled on net/http.Server,
+// the zero value is a valid server with the default configuration.
+type Server struct {
+	Config Config
+
+	mu             sync.Mutex
+	listeners      map[net.Listener]struct{}
+	activeSessions map[*Session]struct{}
+	sessionsGroup  sync.WaitGroup
+	inShutdown     bool
+	doneChan       chan struct{}
+
+	udpFragmentStats proxies.FragmentStats
+}
 
 type Session struct {
-	state SessionState
-	conn  net.Conn
-	err   error
+	state         SessionState
+	conn          net.Conn
+	err           error
+	server        *Server
+	authenticator Authenticator
+	identity      Identity
+	proxyErrors   chan error
+	ctx           context.Context // cancelled when the session is forcefully closed, it bounds the resolving and dialing of the targets
+	cancel        context.CancelFunc
+
+	mu    sync.Mutex // guards proxy, which is also accessed by the server when forcing the session to close
+	proxy proxies.Proxy
+
+	closeOnce  sync.Once
+	connClosed chan struct{} // closed once the connection with the client is closed
 }
 
-func (session *Session) setError(err error) {
-	session.RespondToClientDependingOnState()
-	session.err = err
-	go session.closeClientAfter5Seconds()
+// NewServer creates a server with the given configuration. The server doesn't start accepting clients until Serve or ListenAndServe is called.
+func NewServer(config Config) *Server {
+	return &Server{Config: config}
 }
+
+// Start serves socks5 clients on the listener with the default configuration. If accepting fails, the process is terminated.
+// It is kept for backwards compatibility, Server allows configuring and stopping the server.
 func Start(listener net.Listener) {
+	srv := Server{}
+	if err := srv.Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// UDPFragmentStats returns the counters of the fragmented datagrams received by the UDP relays of all sessions
+func (srv *Server) UDPFragmentStats() *proxies.FragmentStats {
+	return &srv.udpFragmentStats
+}
+
+// ListenAndServe listens on the TCP network address addr and then calls Serve
+func (srv *Server) ListenAndServe(addr string) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(listener)
+}
+
+// Serve accepts clients on the listener and creates a new session for each of them. Serve always returns a non-nil error
+// and closes the listener. After Shutdown the returned error is ErrServerClosed.
+func (srv *Server) Serve(listener net.Listener) error {
+	if !srv.trackListener(listener, true) {
+		return ErrServerClosed
+	}
+	defer srv.trackListener(listener, false)
+	defer listener.Close()
+
+	var slots chan struct{}
+	if srv.Config.MaxConnections > 0 {
+		slots = make(chan struct{}, srv.Config.MaxConnections)
+	}
 	for {
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-srv.getDoneChan():
+				return ErrServerClosed
+			}
+		}
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatal(err)
-			return
+			if srv.shuttingDown() {
+				return ErrServerClosed
+			}
+			srv.Config.logger().Error("failed accepting client", "err", err)
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		session := &Session{state: PendingAuthMethods, conn: conn, server: srv, ctx: ctx, cancel: cancel, connClosed: make(chan struct{})}
+		if !srv.trackSession(session, true) {
+			cancel()
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer srv.trackSession(session, false)
+			session.handler()
+			session.cancel()
+			// the connection may be kept open for a while after the handler, so the client can read the failure reply
+			<-session.connClosed
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new clients by closing all of its listeners, closes the sessions
+// which haven't started proxying yet (i.e. still in the handshake) and then waits for the proxying sessions (CONNECT, BIND and
+// UDP ASSOCIATE traffic) to finish. If ctx expires first, the remaining sessions are forcefully closed and the context's error is returned.
+// In both cases Shutdown returns only once all session goroutines have exited and the connections with the clients are closed.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.inShutdown = true
+	srv.closeDoneChanLocked()
+	var err error
+	for listener := range srv.listeners {
+		if closeErr := listener.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	for session := range srv.activeSessions {
+		if !session.isProxying() {
+			session.forceClose()
+		}
+	}
+	srv.mu.Unlock()
+
+	// No sessions can be added once inShutdown is set, so waiting on the group is safe
+	sessionsDone := make(chan struct{})
+	go func() {
+		srv.sessionsGroup.Wait()
+		close(sessionsDone)
+	}()
+
+	select {
+	case <-sessionsDone:
+		return err
+	case <-ctx.Done():
+		srv.closeSessions()
+		<-sessionsDone
+		return ctx.Err()
+	}
+}
+
+// Forcefully closes all active sessions
+func (srv *Server) closeSessions() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for session := range srv.activeSessions {
+		session.forceClose()
+	}
+}
+
+// Adds or removes the session from the active ones. Returns false if the server is shutting down and the session must not be started.
+func (srv *Server) trackSession(session *Session, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.activeSessions == nil {
+		srv.activeSessions = make(map[*Session]struct{})
+	}
+	if add {
+		if srv.inShutdown {
+			return false
 		}
-		session := Session{state: PendingAuthMethods, conn: conn}
-		go session.handler()
+		srv.activeSessions[session] = struct{}{}
+		srv.sessionsGroup.Add(1)
+	} else {
+		delete(srv.activeSessions, session)
+		srv.sessionsGroup.Done()
 	}
+	return true
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.inShutdown
 }
 
+// Adds or removes the listener from the tracked ones. Returns false if the server is shutting down and the listener must not be used.
+func (srv *Server) trackListener(listener net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.listeners == nil {
+		srv.listeners = make(map[net.Listener]struct{})
+	}
+	if add {
+		if srv.inShutdown {
+			return false
+		}
+		srv.listeners[listener] = struct{}{}
+	} else {
+		delete(srv.listeners, listener)
+	}
+	return true
+}
+
+func (srv *Server) getDoneChan() chan struct{} {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.getDoneChanLocked()
+}
+
+func (srv *Server) getDoneChanLocked() chan struct{} {
+	if srv.doneChan == nil {
+		srv.doneChan = make(chan struct{})
+	}
+	return srv.doneChan
+}
+
+func (srv *Server) closeDoneChanLocked() {
+	ch := srv.getDoneChanLocked()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// Responds to the client with failure depending on the state of the session and terminates it
+func (session *Session) setError(err error) {
+	session.RespondToClientDependingOnState()
+	session.err = err
+	session.state = Closed
+	if err != nil {
+		session.server.Config.logger().Debug("session failed", "client", session.conn.RemoteAddr().String(), "err", err)
+	}
+	select {
+	case <-session.connClosed:
+	default:
+		session.closeClientAfter5Seconds()
+	}
+}
+
+// Drives the session through its states. It returns once the session is closed, including the time spend proxying data.
 func (session *Session) handler() {
 	for {
 		switch session.state {
 		case PendingAuthMethods:
 			session.handleAuth()
+		case PendingAuthentication:
+			session.handleAuthentication()
 		case Authenticated:
 			session.handleCommand()
 		case Proxying:
+			session.proxyErrorHandler()
+		case Closed:
 			return
 		}
 	}
 }
 
+func (session *Session) close() {
+	session.state = Closed
+	session.closeConn()
+}
+
+// Closes the connection with the client, every path closing it goes through here so the server knows when it is closed
+func (session *Session) closeConn() {
+	session.closeOnce.Do(func() {
+		session.conn.Close()
+		close(session.connClosed)
+	})
+}
+
+// Gives the client time to read the failure response. The session stays tracked by the server until the connection is closed,
+// so Shutdown closes it right away instead of waiting for the timer.
 func (session *Session) closeClientAfter5Seconds() {
-	time.Sleep(5 * time.Second)
-	session.conn.Close()
+	time.AfterFunc(5*time.Second, session.closeConn)
+}
+
+// Reports whether the proxy of the session has been started
+func (session *Session) isProxying() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.proxy != nil
+}
+
+// Closes the connection with the client and stops the proxy, if there is one. This unblocks the handler of the session,
+// as every pending read fails and the resolving or dialing of a target is cancelled. It is safe to call it from a goroutine different from the handler.
+func (session *Session) forceClose() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.cancel()
+	session.closeConn()
+	if session.proxy != nil {
+		session.proxy.Stop()
+	}
 }
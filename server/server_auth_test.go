@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"socks5_server/client"
+	"socks5_server/messages/requests/available_auth_methods"
+	"socks5_server/messages/requests/username_password_auth"
+	"socks5_server/messages/responses/accept_auth_method"
+	"socks5_server/messages/responses/username_password_status"
+	"socks5_server/messages/shared"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testUsername = "user"
+const testPassword = "pass"
+
+func Test_Server_UsernamePassword_Auth(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5ServerWithCredentials(StaticCredentials{testUsername: testPassword})
+	socks5SrvAddr := net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort))
+
+	passwords := []string{testPassword, "wrong"}
+	expectedSuccess := []bool{true, false}
+	for i, password := range passwords {
+		conn, err := net.Dial("tcp", socks5SrvAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		method := negotiateAuthMethod(t, conn, []uint16{shared.NoAuthRequired, shared.UsernameAndPassword})
+		if method != shared.UsernameAndPassword {
+			t.Fatalf("Expected server to choose Username/Password, got %v", method)
+		}
+
+		credentials := username_password_auth.UsernamePasswordAuth{}
+		_ = credentials.SetUsername(testUsername)
+		_ = credentials.SetPassword(password)
+		_, err = conn.Write(credentials.ToBytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Failed reading sub-negotiation status. Reason %v", err)
+		}
+		status := username_password_status.UsernamePasswordStatus{}
+		if err := status.Deserialize(buf[:n]); err != nil {
+			t.Fatalf("Failed deserializing sub-negotiation status. Reason %v", err)
+		}
+		if status.IsSuccessful() != expectedSuccess[i] {
+			t.Fatalf("Expected success to be %v for password %v", expectedSuccess[i], password)
+		}
+		conn.Close()
+	}
+}
+
+func Test_Server_UsernamePassword_Rejects_NoAuth(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5ServerWithCredentials(StaticCredentials{testUsername: testPassword})
+	conn, err := net.Dial("tcp", net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	method := negotiateAuthMethod(t, conn, []uint16{shared.NoAuthRequired})
+	if method != shared.NoAcceptableMethods {
+		t.Fatalf("Expected server to respond with no acceptable methods, got %v", method)
+	}
+}
+
+func Test_Client_UsernamePassword_Auth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5ServerWithCredentials(StaticCredentials{testUsername: testPassword})
+	socks5SrvAddr := net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort))
+
+	socks5client, err := client.NewSocks5ClientWithOptions(ctx, socks5SrvAddr, client.ClientOptions{Username: testUsername, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	err = socks5client.Connect([]uint16{shared.NoAuthRequired, shared.UsernameAndPassword})
+	if err != nil {
+		t.Fatalf("Failed authenticating. Reason %v", err)
+	}
+	if socks5client.State() != client.Authenticated {
+		t.Fatalf("Failed authentication")
+	}
+
+	rejectedClient, err := client.NewSocks5ClientWithOptions(ctx, socks5SrvAddr, client.ClientOptions{Username: testUsername, Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejectedClient.Close()
+	err = rejectedClient.Connect([]uint16{shared.UsernameAndPassword})
+	var authErr client.AuthenticationFailedError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected AuthenticationFailedError, got %v", err)
+	}
+}
+
+func negotiateAuthMethod(t *testing.T, conn net.Conn, methods []uint16) uint16 {
+	authMsg := available_auth_methods.AvailableAuthMethods{}
+	if err := authMsg.AddMultipleMethods(methods); err != nil {
+		t.Fatal(err)
+	}
+	_, err := conn.Write(authMsg.ToBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed reading accepted auth method. Reason %v", err)
+	}
+	accepted := accept_auth_method.AcceptAuthMethod{}
+	if err := accepted.Deserialize(buf[:n]); err != nil {
+		t.Fatalf("Failed deserializing accepted auth method. Reason %v", err)
+	}
+	return accepted.Method()
+}
+
+// Private method, which accepts the client without any sub-negotiation. Used to verify method negotiation.
+type privateMethodAuthenticator struct{}
+
+func (privateMethodAuthenticator) Method() uint16 {
+	return shared.PrivateMethodsStart
+}
+
+func (privateMethodAuthenticator) Authenticate(conn net.Conn) (Identity, error) {
+	return Identity{Method: shared.PrivateMethodsStart}, nil
+}
+
+func Test_Server_Chooses_Method_By_Server_Preference(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5ServerWithAuthenticators(privateMethodAuthenticator{}, NoAuthAuthenticator{})
+	socks5SrvAddr := net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort))
+
+	offered := [][]uint16{{shared.NoAuthRequired, shared.PrivateMethodsStart}, {shared.NoAuthRequired}, {shared.UsernameAndPassword}}
+	expected := []uint16{shared.PrivateMethodsStart, shared.NoAuthRequired, shared.NoAcceptableMethods}
+	for i := range offered {
+		conn, err := net.Dial("tcp", socks5SrvAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		method := negotiateAuthMethod(t, conn, offered[i])
+		if method != expected[i] {
+			t.Fatalf("Expected server to choose %v when offered %v, got %v", expected[i], offered[i], method)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"socks5_server/client"
+	"socks5_server/messages/responses/command_response"
+	"socks5_server/server/proxies"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Client_Bind_Listener_Accepts_Peer(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5Server()
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	listener, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// the peer connects to the address announced in the first reply and sends data right away,
+	// which must not be consumed together with the second reply
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if _, err := peer.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != peer.LocalAddr().String() {
+		t.Fatalf("Expected the peer address %v, got %v", peer.LocalAddr(), conn.RemoteAddr())
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "Hello" {
+		t.Fatalf("Expected the data of the peer, got %q, err %v", buf, err)
+	}
+	if _, err := conn.Write([]byte("World")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(peer, buf); err != nil || string(buf) != "World" {
+		t.Fatalf("Expected the data of the client, got %q, err %v", buf, err)
+	}
+
+	if _, err := listener.Accept(ctx); !errors.Is(err, client.ErrAlreadyAccepted) {
+		t.Fatal("Expected ErrAlreadyAccepted, got", err)
+	}
+}
+
+func Test_Client_Bind_Accept_Respects_Context(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5Server()
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	listener, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := listener.Accept(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected context.DeadlineExceeded, got", err)
+	}
+}
+
+func Test_Client_Concurrent_Binds(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5Server()
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	first, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if first.Addr().String() == second.Addr().String() {
+		t.Fatal("Expected each BIND to have its own listener, got", first.Addr())
+	}
+
+	// accept the peers in the reverse order, to make sure the listeners are independent
+	for _, listener := range []*client.BindListener{second, first} {
+		peer, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		conn, err := listener.Accept(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr().String() != peer.LocalAddr().String() {
+			t.Fatalf("Expected the peer address %v, got %v", peer.LocalAddr(), conn.RemoteAddr())
+		}
+		conn.Close()
+		peer.Close()
+	}
+}
+
+func Test_Client_Bind_Uses_Configured_Ports_And_External_IP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	config := Config{BindPorts: proxies.PortRange{First: port, Last: port}, BindExternalIP: net.ParseIP("192.0.2.10")}
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(config)
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	bind, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bind.Close()
+	expected := net.JoinHostPort("192.0.2.10", strconv.Itoa(int(port)))
+	if bind.Addr().String() != expected {
+		t.Fatalf("Expected the external address %v, got %v", expected, bind.Addr())
+	}
+
+	// the range has a single port, which is taken by the first BIND
+	if _, err := dialer.Bind(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Fatal("Expected the second BIND to fail")
+	}
+}
+
+func Test_Client_Bind_Rejects_Unexpected_Peer_Until_Timeout(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{BindAcceptTimeout: 200 * time.Millisecond})
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	// the expected peer is 192.0.2.1, while the test connects from the loopback
+	listener, err := dialer.Bind(context.Background(), "192.0.2.1:21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("Expected the unexpected peer to be disconnected, got", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = listener.Accept(ctx)
+	var cmdErr client.CommandFailedError
+	if !errors.As(err, &cmdErr) || cmdErr.Status != command_response.TtlExpired {
+		t.Fatal("Expected the BIND to fail with TtlExpired, got", err)
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("Expected the listener to be closed after the timeout")
+	}
+}
+
+func Test_Server_Shutdown_Closes_Connected_Bind_Peer(t *testing.T) {
+	srv, proxyListener := startServer(Config{})
+	dialer := client.NewDialer(proxyListener.Addr().String(), client.ClientOptions{})
+	listener, err := dialer.Bind(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected Shutdown to force-close the BIND, got", err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := peer.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Fatal("Expected the peer to be closed by the server, got", err)
+	}
+}
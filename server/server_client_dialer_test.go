@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"socks5_server/client"
+	"socks5_server/client/sockstests"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Client_Dialer_Connects_Through_Proxy(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5Server()
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		// the echo server accepts a single client
+		_, echoPort := sockstests.TcpEchoServer()
+		target := net.JoinHostPort(host, strconv.Itoa(int(echoPort)))
+		conn, err := dialer.DialContext(context.Background(), "tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.RemoteAddr().String() != target {
+			t.Fatalf("Expected remote address %v, got %v", target, conn.RemoteAddr())
+		}
+		if _, err := conn.Write([]byte("Hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "Hello" {
+			t.Fatalf("Expected echo from %v, got %q, err %v", target, buf[:n], err)
+		}
+		conn.Close()
+	}
+}
+
+func Test_Client_Dialer_As_Http_Transport(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5Server()
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.RemoteAddr))
+	}))
+	defer httpServer.Close()
+
+	httpClient := http.Client{Transport: &http.Transport{DialContext: dialer.DialContext}}
+	resp, err := httpClient.Get(httpServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected status 200, got", resp.StatusCode)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Client_Dialer_Respects_Context_Deadline(t *testing.T) {
+	// accepts the connection but never replies to the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	dialer := client.NewDialer(listener.Addr().String(), client.ClientOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = dialer.DialContext(ctx, "tcp", "127.0.0.1:80")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected context.DeadlineExceeded, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatal("Expected the handshake to stop at the deadline, took", elapsed)
+	}
+}
+
+func Test_Client_Dialer_Rejects_Unsupported_Network(t *testing.T) {
+	dialer := client.NewDialer("127.0.0.1:1080", client.ClientOptions{})
+	if _, err := dialer.DialContext(context.Background(), "udp", "127.0.0.1:53"); err == nil {
+		t.Fatal("Expected error for the udp network")
+	}
+}
+
+// Counts the connections opened to the proxy, it only implements proxy.Dialer
+type countingForward struct {
+	dials int
+}
+
+func (forward *countingForward) Dial(network string, addr string) (net.Conn, error) {
+	forward.dials++
+	return net.Dial(network, addr)
+}
+
+func Test_Client_FromURL_Authenticates_With_URL_Credentials(t *testing.T) {
+	proxyAddr, proxyPort := startSocks5ServerWithCredentials(StaticCredentials{"alice": "secret"})
+	_, echoPort := sockstests.TcpEchoServer()
+	u, err := url.Parse("socks5h://alice:secret@" + net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := &countingForward{}
+	dialer, err := client.FromURL(u, forward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(echoPort))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if forward.dials != 1 {
+		t.Fatal("Expected the forward dialer to open the connection to the proxy, got dials:", forward.dials)
+	}
+	if _, err := conn.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo, got %q, err %v", buf[:n], err)
+	}
+}
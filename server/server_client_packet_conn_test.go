@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"socks5_server/client"
+	"socks5_server/client/sockstests"
+	"socks5_server/messages/shared"
+	"socks5_server/server/resolvers"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Client_PacketConn_Echo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5Server()
+	echoAddr, echoPort := sockstests.UdpEchoServerOn("127.0.0.1:0")
+	socks5client, err := client.NewSocks5Client(ctx, net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	conn, err := socks5client.UDPAssociate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	target := &net.UDPAddr{IP: net.ParseIP(echoAddr), Port: int(echoPort)}
+	if n, err := conn.WriteTo([]byte("Hello"), target); err != nil || n != 5 {
+		t.Fatalf("Expected the payload length to be written, got %v, err %v", n, err)
+	}
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo, got %q", buf[:n])
+	}
+	if from.String() != target.String() {
+		t.Fatalf("Expected the reply to come from %v, got %v", target, from)
+	}
+}
+
+func Test_Client_Dialer_ListenPacket_With_Hostname(t *testing.T) {
+	resolver := resolvers.StaticResolver{Hosts: map[string][]net.IP{"echo.internal": {net.ParseIP("127.0.0.1")}}}
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{Resolver: resolver})
+	_, echoPort := sockstests.UdpEchoServerOn("127.0.0.1:0")
+	dialer := client.NewDialer(net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)), client.ClientOptions{})
+	conn, err := dialer.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the hostname is resolved by the proxy and reported back as the source of the reply
+	target := client.Addr{Net: "udp", Host: "echo.internal", Port: echoPort}
+	if _, err := conn.WriteTo([]byte("Hello"), target); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo, got %q, err %v", buf[:n], err)
+	}
+	if from.Network() != "udp" || from.String() != target.String() {
+		t.Fatalf("Expected the reply to come from %v, got %v", target, from)
+	}
+}
+
+func Test_Client_PacketConn_Ends_With_Control_Connection(t *testing.T) {
+	srv, listener := startServer(Config{})
+	dialer := client.NewDialer(listener.Addr().String(), client.ClientOptions{})
+	conn, err := dialer.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the server closes the TCP connection of every session
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = srv.Shutdown(ctx)
+
+	_, _, err = conn.ReadFrom(make([]byte, 1024))
+	if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("Expected the read to fail once the control connection is closed, got", err)
+	}
+}
+
+func Test_Server_Ends_UDP_Association_With_Control_Connection(t *testing.T) {
+	srv, listener := startServer(Config{})
+	dialer := client.NewDialer(listener.Addr().String(), client.ClientOptions{})
+	conn, err := dialer.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing the PacketConn closes the TCP connection, which must end the session on the server
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("Expected the session to end with the control connection, got", err)
+	}
+}
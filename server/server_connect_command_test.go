@@ -2,10 +2,16 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"socks5_server/client"
 	"socks5_server/client/sockstests"
+	"socks5_server/messages/responses/command_response"
 	"socks5_server/messages/shared"
+	"socks5_server/server/resolvers"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -28,10 +34,14 @@ func Test_Client_Connect(t *testing.T) {
 		t.Fatalf("Failed authentication")
 	}
 	// send connect request
-	_, _, err = socks5client.ConnectRequest(addr, port)
+	bndAddr, bndPort, err := socks5client.ConnectRequest(addr, port)
 	if err != nil {
 		t.Fatalf("Failed sending connect request to Dante. Reason %v", err)
 	}
+	// the echo server listens on the loopback, so the proxy must have used it for the outbound connection
+	if bndAddr != "127.0.0.1" || bndPort == 0 {
+		t.Fatalf("Expected the bound address of the outbound connection, got %v:%v", bndAddr, bndPort)
+	}
 	if socks5client.State() != client.CommandAccepted {
 		t.Fatalf("Failed sending connect request to Dante")
 	}
@@ -56,3 +66,255 @@ func Test_Client_Connect(t *testing.T) {
 	}
 	socks5client.Close()
 }
+
+func Test_Client_Connect_Reports_Refused_Connection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5Server()
+	socks5SrvAddr := fmt.Sprintf("%s:%d", proxyAddr, proxyPort)
+	// reserve a port and release it, so nothing is listening on it
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	socks5client, err := client.NewSocks5Client(ctx, socks5SrvAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	_, _, err = socks5client.ConnectRequest("127.0.0.1", closedPort)
+	var cmdErr client.CommandFailedError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("Expected CommandFailedError, got %v", err)
+	}
+	if cmdErr.Status != command_response.ConnectionRefused {
+		t.Fatalf("Expected status %v, got %v", command_response.ConnectionRefused, cmdErr.Status)
+	}
+}
+
+func Test_Client_Connect_FQDN(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5Server()
+	socks5SrvAddr := fmt.Sprintf("%s:%d", proxyAddr, proxyPort)
+
+	// remote resolution sends the name to the server, local resolution sends the IP
+	for _, resolveLocally := range []bool{false, true} {
+		_, port := sockstests.TcpEchoServer()
+		socks5client, err := client.NewSocks5ClientWithOptions(ctx, socks5SrvAddr, client.ClientOptions{ResolveLocally: resolveLocally})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+			t.Fatalf("Failed authentication. Reason %v", err)
+		}
+		if _, _, err := socks5client.ConnectRequest("localhost", port); err != nil {
+			t.Fatalf("Failed sending connect request with ResolveLocally=%v. Reason %v", resolveLocally, err)
+		}
+		rw, err := socks5client.GetReaderWriter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rw.Write([]byte("Hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1024)
+		n, err := rw.Read(buf)
+		if err != nil || string(buf[:n]) != "Hello" {
+			t.Fatalf("Expected echo with ResolveLocally=%v, got %q, err %v", resolveLocally, buf[:n], err)
+		}
+		socks5client.Close()
+	}
+}
+
+func Test_Client_Connect_Uses_Configured_Resolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	resolver := resolvers.StaticResolver{Hosts: map[string][]net.IP{"echo.internal": {net.ParseIP("127.0.0.1")}}}
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{Resolver: resolver})
+	socks5SrvAddr := fmt.Sprintf("%s:%d", proxyAddr, proxyPort)
+	_, port := sockstests.TcpSinkServer()
+
+	hosts := []string{"echo.internal", "missing.internal"}
+	expectedStatus := []uint16{command_response.Success, command_response.HostUnreachable}
+	for i, host := range hosts {
+		socks5client, err := client.NewSocks5Client(ctx, socks5SrvAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+			t.Fatalf("Failed authentication. Reason %v", err)
+		}
+		_, _, err = socks5client.ConnectRequest(host, port)
+		status := uint16(command_response.Success)
+		var cmdErr client.CommandFailedError
+		if errors.As(err, &cmdErr) {
+			status = cmdErr.Status
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if status != expectedStatus[i] {
+			t.Fatalf("Expected status %v for %v, got %v", expectedStatus[i], host, status)
+		}
+		socks5client.Close()
+	}
+}
+
+// Resolves every name to an empty list without an error, which some resolvers do for names without A/AAAA records
+type emptyResolver struct{}
+
+func (emptyResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return []net.IP{}, nil
+}
+
+func Test_Client_Connect_Reports_Host_Without_Addresses_As_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{Resolver: emptyResolver{}})
+	socks5client, err := client.NewSocks5Client(ctx, net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	_, _, err = socks5client.ConnectRequest("empty.internal", 80)
+	var cmdErr client.CommandFailedError
+	if !errors.As(err, &cmdErr) || cmdErr.Status != command_response.HostUnreachable {
+		t.Fatalf("Expected status %v, got %v", command_response.HostUnreachable, err)
+	}
+}
+
+// Sends every connection to target, regardless of the requested address, like a dialer chaining through another proxy would
+type redirectingDialer struct {
+	target    string
+	addresses chan string
+}
+
+func (dialer *redirectingDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer.addresses <- address
+	var netDialer net.Dialer
+	return netDialer.DialContext(ctx, network, dialer.target)
+}
+
+func Test_Client_Connect_Uses_Configured_Dialer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	echoAddr, echoPort := sockstests.TcpEchoServer()
+	dialer := &redirectingDialer{target: net.JoinHostPort(echoAddr, strconv.Itoa(int(echoPort))), addresses: make(chan string, 1)}
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{Dialer: dialer})
+	socks5client, err := client.NewSocks5Client(ctx, net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	if _, _, err := socks5client.ConnectRequest("192.0.2.1", 80); err != nil {
+		t.Fatal(err)
+	}
+	if address := <-dialer.addresses; address != "192.0.2.1:80" {
+		t.Fatal("Expected the dialer to be asked for 192.0.2.1:80, got", address)
+	}
+	rw, err := socks5client.GetReaderWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := rw.Read(buf)
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo through the dialer, got %q, err %v", buf[:n], err)
+	}
+}
+
+// Blocks every dial until its context is done, like a dialer to an unreachable target, and reports the error of the context
+type blockingDialer struct {
+	started   chan struct{}
+	cancelled chan error
+}
+
+func (dialer *blockingDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer.started <- struct{}{}
+	<-ctx.Done()
+	dialer.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func Test_Server_Shutdown_Cancels_Pending_Connect_Dial(t *testing.T) {
+	dialer := &blockingDialer{started: make(chan struct{}, 2), cancelled: make(chan error, 2)}
+	srv, listener := startServer(Config{Dialer: dialer, DialTimeout: time.Minute})
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 1, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	<-dialer.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("Expected the pending dial to be cancelled, got", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatal("Expected Shutdown to cancel the dial right away, took", elapsed)
+	}
+	if err := <-dialer.cancelled; !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected the dial to be cancelled, got", err)
+	}
+}
+
+func Test_Client_Connect_Dial_Timeout_Bounds_Configured_Dialer(t *testing.T) {
+	dialer := &blockingDialer{started: make(chan struct{}, 2), cancelled: make(chan error, 2)}
+	_, listener := startServer(Config{Dialer: dialer, DialTimeout: 200 * time.Millisecond})
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 192, 0, 2, 1, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatal("Expected the dial to be bounded by DialTimeout, took", elapsed)
+	}
+	if uint16(reply[1]) != command_response.TtlExpired {
+		t.Fatalf("Expected status %v, got %v", command_response.TtlExpired, reply[1])
+	}
+	if err := <-dialer.cancelled; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected the dial to reach its deadline, got", err)
+	}
+}
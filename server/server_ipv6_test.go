@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net"
+	"socks5_server/client"
+	"socks5_server/client/sockstests"
+	"socks5_server/messages/encapsulation/udp"
+	"socks5_server/messages/shared"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Client_Connect_IPv6(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, listener := startServerOn(Config{}, "[::1]:0")
+	addr, port := sockstests.TcpEchoServerOn("[::1]:0")
+
+	socks5client, err := client.NewSocks5Client(ctx, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	bndAddr, _, err := socks5client.ConnectRequest(addr, port)
+	if err != nil {
+		t.Fatalf("Failed sending connect request. Reason %v", err)
+	}
+	if bndAddr != "::1" {
+		t.Fatalf("Expected bound address ::1, got %v", bndAddr)
+	}
+	rw, err := socks5client.GetReaderWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := rw.Read(buf)
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo, got %q, err %v", buf[:n], err)
+	}
+}
+
+func Test_Client_UDP_Associate_IPv6(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, listener := startServerOn(Config{}, "[::1]:0")
+	addr, port := sockstests.UdpEchoServerOn("[::1]:0")
+
+	socks5client, err := client.NewSocks5Client(ctx, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	relayAddr, relayPort, err := socks5client.UDPAssociateRequest("::", 0)
+	if err != nil {
+		t.Fatalf("Failed sending UDP associate request. Reason %v", err)
+	}
+	if relayAddr != "::1" {
+		t.Fatalf("Expected the relay on ::1, got %v", relayAddr)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(relayAddr, strconv.Itoa(int(relayPort))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := udp.UDPDatagram{DST_ADDR: shared.NewDstAddr(addr), DST_PORT: port, DATA: []byte(dataSendToUDPEcho)}
+	data, err := msg.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed reading from UDP. Reason %v", err)
+	}
+	response := udp.UDPDatagram{}
+	if err := response.Deserialize(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if response.DST_ADDR.Type != shared.ATYP_IPV6 || string(response.DATA) != dataSendToUDPEcho {
+		t.Fatalf("Unexpected response %+v", response)
+	}
+}
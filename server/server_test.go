@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"socks5_server/client"
+	"socks5_server/client/sockstests"
+	"socks5_server/messages/requests/command_request"
+	"socks5_server/messages/shared"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Server_Shutdown_Stops_Serve(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(Config{})
+	serveErr := make(chan error)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed shutting down. Reason %v", err)
+	}
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Expected ErrServerClosed, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("Serve didn't return after Shutdown")
+	}
+	if err := srv.ListenAndServe("127.0.0.1:0"); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed after Shutdown, got %v", err)
+	}
+}
+
+func Test_Server_Rejects_Not_Allowed_Commands(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	proxyAddr, proxyPort := startSocks5ServerWithConfig(Config{AllowedCommands: []uint16{command_request.UDP_ASSOCIATE}})
+	addr, port := sockstests.TcpEchoServer()
+
+	socks5client, err := client.NewSocks5Client(ctx, net.JoinHostPort(proxyAddr, strconv.Itoa(proxyPort)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5client.Close()
+	if err := socks5client.Connect([]uint16{shared.NoAuthRequired}); err != nil {
+		t.Fatalf("Failed authentication. Reason %v", err)
+	}
+	_, _, err = socks5client.ConnectRequest(addr, port)
+	if err == nil {
+		t.Fatal("Expected CONNECT to be rejected")
+	}
+}
+
+func Test_Server_Shutdown_Drains_Active_Sessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	srv, listener := startServer(Config{})
+	addr, port := sockstests.TcpEchoServer()
+	socks5client := openConnectCmd(ctx, "127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), addr, port)
+	defer socks5client.Close()
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	// the tunnel must keep working while the server is draining
+	rw, err := socks5client.GetReaderWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.Write([]byte("Hello")); err != nil {
+		t.Fatalf("Failed writing while draining. Reason %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := rw.Read(buf)
+	if err != nil || string(buf[:n]) != "Hello" {
+		t.Fatalf("Expected echo while draining, got %q, err %v", buf[:n], err)
+	}
+	// the echo server closes the connection after the first message, the client sees EOF and closes its side, which ends the session
+	if n, err := rw.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Expected EOF once the echo server closed, got %q, err %v", buf[:n], err)
+	}
+	socks5client.Close()
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Expected Shutdown to finish after the session ended, got %v", err)
+	}
+}
+
+func Test_Server_Shutdown_Force_Closes_After_Deadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	srv, listener := startServer(Config{})
+	addr, port := sockstests.TcpSinkServer()
+	socks5client := openConnectCmd(ctx, "127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), addr, port)
+	defer socks5client.Close()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Shutdown to report the deadline, got %v", err)
+	}
+	rw, err := socks5client.GetReaderWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	if _, err := rw.Read(buf); err == nil {
+		t.Fatal("Expected the tunnel to be closed by the server")
+	}
+}
+
+func Test_Server_Ends_Idle_Sessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	srv, listener := startServer(Config{IdleTimeouts: map[uint16]time.Duration{command_request.CONNECT: 200 * time.Millisecond}})
+	addr, port := sockstests.TcpSinkServer()
+	socks5client := openConnectCmd(ctx, "127.0.0.1", uint16(listener.Addr().(*net.TCPAddr).Port), addr, port)
+	defer socks5client.Close()
+
+	rw, err := socks5client.GetReaderWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// neither the client nor the sink server sends anything, so the server closes the tunnel
+	if n, err := rw.Read(make([]byte, 16)); n != 0 || err == nil {
+		t.Fatal("Expected the idle session to be closed, got", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("Expected no active session, got", err)
+	}
+}
+
+func Test_Server_Ends_Idle_Bind_Sessions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	srv, proxyListener := startServer(Config{IdleTimeouts: map[uint16]time.Duration{command_request.BIND: 200 * time.Millisecond}})
+	dialer := client.NewDialer(proxyListener.Addr().String(), client.ClientOptions{})
+	listener, err := dialer.Bind(ctx, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	peer, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	conn, err := listener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// neither the client nor the peer sends anything, so the server closes both sides
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := conn.Read(make([]byte, 16)); n != 0 || err == nil {
+		t.Fatal("Expected the idle session to be closed, got", err)
+	}
+	_ = peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := peer.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Fatal("Expected the peer of the idle session to be closed, got", err)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("Expected no active session, got", err)
+	}
+}
+
+func Test_Server_Shutdown_Closes_Sessions_In_Handshake(t *testing.T) {
+	srv, listener := startServer(Config{})
+	// the client connects, but never sends its auth methods
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForSessions(t, srv, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	started := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal("Expected the session in the handshake to be closed, got", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatal("Expected Shutdown to close the session right away, took", elapsed)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatal("Expected the connection to be closed by the server, got", err)
+	}
+}
+
+func Test_Server_Shutdown_Waits_For_Failed_Sessions_To_Be_Closed(t *testing.T) {
+	srv, listener := startServer(Config{})
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// No Auth, then a request with an invalid version, which is answered with a general failure
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte{0x04, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection of the failed session is kept open for the client to read the reply, Shutdown must close it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatal("Expected the connection to be closed once Shutdown returned, got", err)
+	}
+}
+
+// Waits until the server tracks the given number of sessions
+func waitForSessions(t *testing.T, srv *Server, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.activeSessionsCount() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sessions, got %d", count, srv.activeSessionsCount())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (srv *Server) activeSessionsCount() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.activeSessions)
+}
@@ -5,13 +5,34 @@ import (
 )
 
 func startSocks5Server() (string, int) {
-	listener, err := net.Listen("tcp4", "127.0.0.1:0")
-	if err != nil {
-		panic(err)
-	}
-	//	srv := Socks5Server{Listener: listener}
-	go Start(listener)
+	return startSocks5ServerWithConfig(Config{})
+}
+
+func startSocks5ServerWithCredentials(credentials CredentialStore) (string, int) {
+	return startSocks5ServerWithAuthenticators(&UsernamePasswordAuthenticator{Credentials: credentials})
+}
+
+func startSocks5ServerWithAuthenticators(authenticators ...Authenticator) (string, int) {
+	return startSocks5ServerWithConfig(Config{Authenticators: authenticators})
+}
+
+func startSocks5ServerWithConfig(config Config) (string, int) {
+	_, listener := startServer(config)
 	addr := listener.Addr().(*net.TCPAddr).IP.String()
 	port := listener.Addr().(*net.TCPAddr).Port
 	return addr, port
 }
+
+func startServer(config Config) (*Server, net.Listener) {
+	return startServerOn(config, "127.0.0.1:0")
+}
+
+func startServerOn(config Config, address string) (*Server, net.Listener) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		panic(err)
+	}
+	srv := NewServer(config)
+	go srv.Serve(listener)
+	return srv, listener
+}
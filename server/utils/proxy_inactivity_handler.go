@@ -1,29 +1,89 @@
 package utils
 
 import (
-	"net/http"
-	"socks5_server/server/proxies"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"time"
 )
 
+// ErrIdleTimeout is wrapped by the error of the proxies which were stopped because no data was transferred for their idle timeout
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// The activity is checked this many times per period, so an idle proxy is stopped at most a quarter of the period late
+const checksPerPeriod = 4
+
+// Direction of the data flow of a proxy
+type Direction int
+
+const (
+	// Upstream is the data send by the client to the remote side
+	Upstream Direction = iota
+	// Downstream is the data send by the remote side to the client
+	Downstream
+)
+
+func (direction Direction) String() string {
+	if direction == Upstream {
+		return "upstream"
+	}
+	return "downstream"
+}
+
+// InactivityHandler tracks when data was last transferred in each direction of a proxy. The proxy is idle once neither direction
+// has been active for MaxInactivityPeriod. It is safe for concurrent use, so each direction can be copied by its own goroutine.
+// A nil handler is never idle, which is how a proxy without an idle timeout is represented.
 type InactivityHandler struct {
 	MaxInactivityPeriod time.Duration
-	lastActive          time.Time
-	proxy               *proxies.Proxy
+	lastActive          [2]atomic.Int64 // unix nanoseconds, per Direction
 }
 
-func NewInactivityHandler(proxy *proxies.Proxy, maxPeriod time.Duration) *InactivityHandler {
-	return &InactivityHandler{MaxInactivityPeriod: maxPeriod, proxy: proxy, lastActive: time.Now()}
+// NewInactivityHandler returns a handler for which both directions were active just now. It returns nil if maxPeriod isn't positive.
+func NewInactivityHandler(maxPeriod time.Duration) *InactivityHandler {
+	if maxPeriod <= 0 {
+		return nil
+	}
+	handler := &InactivityHandler{MaxInactivityPeriod: maxPeriod}
+	handler.RecordActivityNow(Upstream)
+	handler.RecordActivityNow(Downstream)
+	return handler
 }
 
-func (handler *InactivityHandler) recordActivityNow() {
-	handler.lastActive = time.Now()
+// RecordActivityNow marks the direction as active
+func (handler *InactivityHandler) RecordActivityNow(direction Direction) {
+	if handler == nil {
+		return
+	}
+	handler.lastActive[direction].Store(time.Now().UnixNano())
 }
 
-func (handler *InactivityHandler) StartMonitoring() {
-	for {
-		if time.Now().After(handler.lastActive.Add(handler.MaxInactivityPeriod)) {
-			handler.proxy.Stop()
-		}
+// LastActive returns when data was last transferred in the direction
+func (handler *InactivityHandler) LastActive(direction Direction) time.Time {
+	return time.Unix(0, handler.lastActive[direction].Load())
+}
+
+// IsIdle reports whether neither direction has been active for MaxInactivityPeriod
+func (handler *InactivityHandler) IsIdle() bool {
+	if handler == nil {
+		return false
+	}
+	return time.Since(handler.lastAnyActivity()) >= handler.MaxInactivityPeriod
+}
+
+// NextCheck returns the deadline for the reads of the proxy, after which it checks whether it is idle. The zero time means no deadline.
+func (handler *InactivityHandler) NextCheck() time.Time {
+	if handler == nil {
+		return time.Time{}
 	}
+	return time.Now().Add(handler.MaxInactivityPeriod / checksPerPeriod)
+}
+
+// Err returns the reason of stopping an idle proxy, it wraps ErrIdleTimeout
+func (handler *InactivityHandler) Err() error {
+	return fmt.Errorf("%w: no data for %v, last upstream at %v, last downstream at %v", ErrIdleTimeout, handler.MaxInactivityPeriod,
+		handler.LastActive(Upstream).Format(time.RFC3339), handler.LastActive(Downstream).Format(time.RFC3339))
+}
+
+func (handler *InactivityHandler) lastAnyActivity() time.Time {
+	return time.Unix(0, max(handler.lastActive[Upstream].Load(), handler.lastActive[Downstream].Load()))
 }
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_InactivityHandler_Must_Be_Idle_Once_Both_Directions_Are_Inactive(t *testing.T) {
+	handler := NewInactivityHandler(200 * time.Millisecond)
+	if handler.IsIdle() {
+		t.Fatal("Expected a new handler to be active")
+	}
+	time.Sleep(120 * time.Millisecond)
+	handler.RecordActivityNow(Downstream)
+	time.Sleep(120 * time.Millisecond)
+	if handler.IsIdle() {
+		t.Fatal("Expected the downstream activity to keep the handler active")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !handler.IsIdle() {
+		t.Fatal("Expected the handler to be idle")
+	}
+	if !handler.LastActive(Downstream).After(handler.LastActive(Upstream)) {
+		t.Fatal("Expected the activity to be tracked per direction")
+	}
+	if err := handler.Err(); !errors.Is(err, ErrIdleTimeout) {
+		t.Fatal("Expected the reason to wrap ErrIdleTimeout, got", err)
+	}
+}
+
+func Test_InactivityHandler_Without_Period_Must_Never_Be_Idle(t *testing.T) {
+	handler := NewInactivityHandler(0)
+	if handler != nil {
+		t.Fatal("Expected no handler without a period")
+	}
+	handler.RecordActivityNow(Upstream)
+	if handler.IsIdle() || !handler.NextCheck().IsZero() {
+		t.Fatal("Expected a nil handler to be never idle and to have no deadline")
+	}
+}